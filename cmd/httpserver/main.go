@@ -1,8 +1,8 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
+	"http-from-tcp/internal/digest"
 	"http-from-tcp/internal/headers"
 	"http-from-tcp/internal/request"
 	"http-from-tcp/internal/response"
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -71,60 +72,54 @@ func main() {
 //   }
 
   var newH server.Handler = func(w *response.Writer, req *request.Request) {
-    path := req.RequestLine.RequestTarget
+    path := req.RequestLine.URL.EscapedPath()
+    if !strings.HasPrefix(path, "/httpbin/") {
+      return
+    }
+    newPath := strings.TrimPrefix(path, "/httpbin/")
+    url := fmt.Sprintf("https://httpbin.org/%s", newPath)
+    if query := req.RequestLine.URL.RawQuery; query != "" {
+      url += "?" + query
+    }
+    r, err := http.Get(url)
+    if err != nil {
+      log.Println("error getting response from httpbin.org: ", err)
+      w.WriteHeader(response.StatusCode502)
+      return
+    }
+    defer r.Body.Close()
+
     h := headers.NewHeaders()
-    h.Set("Connection", "close")
     h.Set("Transfer-Encoding", "chunked")
     h.Set("Content-Type", "text/plain")
-    h.Set("Trailer", "X-Content-SHA256,X-Content-Length")
+    // the digest and length are only known once the whole body is sent
+    w.DigestBody(digest.SHA256)
+    w.DeclareTrailer("X-Content-Length")
+    w.WriteStatusLine(response.StatusCode200)
+    w.WriteHeaders(h)
 
-    fullData := []byte{}
-    if strings.HasPrefix(path, "/httpbin/") {
-      newPath := strings.TrimPrefix(path, "/httpbin/")
-      url := fmt.Sprintf("https://httpbin.org/%s", newPath)
-      r, err := http.Get(url)
-      if err != nil {
-        log.Fatal("error getting response from httbin.org", err)
-      }
-      w.WriteStatusLine(200)
-      w.WriteHeaders(h)
-      for {
-        buf := make([]byte, 1024)
-        n, err := r.Body.Read(buf)
-        if err != nil && err != io.EOF {
-          log.Fatal("super bad err when reading body: ", err)
-        }
-        originalErr := err
-        fmt.Printf("Read %d bytes from httpbin.org\n", n)
-        fmt.Printf("Data: %s\n", string(buf[:n]))
-        fullData = append(fullData, buf[:n]...)
-        if n == 0 {
-          sha256Sum := sha256.Sum256(fullData)
-          trailers := headers.NewHeaders()
-          trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sha256Sum))
-          trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(fullData)))
-          n, err = w.WriteChunkedBodyDone(trailers)
-          if err != nil {
-            log.Fatal("super bad err when chunking: ", err)
-          }
-          return 
-        }
-        n, err = w.WriteChunkedBody(buf[:n])
-        if err != nil {
-          log.Fatal("super bad err when reading body: ", err)
-        }
-        if originalErr == io.EOF {
-          sha256Sum := sha256.Sum256(fullData)
-          trailers := headers.NewHeaders()
-          trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sha256Sum))
-          trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(fullData)))
-          n, err = w.WriteChunkedBodyDone(trailers)
-          if err != nil {
-            log.Fatal("super bad err when chunking: ", err)
-          }
-          return 
+    length := 0
+    buf := make([]byte, 1024)
+    for {
+      n, err := r.Body.Read(buf)
+      if n > 0 {
+        length += n
+        if _, err := w.WriteChunkedBody(buf[:n]); err != nil {
+          log.Println("error writing chunk: ", err)
+          return
         }
       }
+      if err == io.EOF {
+        break
+      }
+      if err != nil {
+        log.Println("error reading body from httpbin.org: ", err)
+        return
+      }
+    }
+    w.SetTrailer("X-Content-Length", strconv.Itoa(length))
+    if _, err := w.WriteChunkedBodyDone(nil); err != nil {
+      log.Println("error ending chunked body: ", err)
     }
   }
 
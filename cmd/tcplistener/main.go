@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"http-from-tcp/internal/request"
+	"io"
 	"log"
 	"net"
 )
@@ -29,10 +30,14 @@ func main() {
 		fmt.Printf("- Target: %s\n", r.RequestLine.RequestTarget)
 		fmt.Printf("- Version: %s\n", r.RequestLine.HttpVersion)
 		fmt.Println("Headers")
-		for key, value := range(r.Headers) {
+		for key, value := range r.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, value)
 		}
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+      log.Fatal("error reading request body", err)
+    }
     fmt.Println("Body")
-    fmt.Printf("%s\n", string(r.Body))
+    fmt.Printf("%s\n", string(body))
 	}
 }
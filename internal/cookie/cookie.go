@@ -0,0 +1,240 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"http-from-tcp/internal/headers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SameSite is the value of a cookie's SameSite attribute.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, letting the user agent pick.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie as sent in a Set-Cookie field (RFC 6265 section 4.1).
+// Only Name and Value are set on cookies parsed from a request's Cookie
+// field.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time // zero means no Expires attribute
+	// MaxAge is the number of seconds until the cookie expires. Zero means no
+	// Max-Age attribute, a negative value deletes the cookie right away and
+	// is sent as Max-Age=0.
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+var ErrNoCookie = errors.New("cookie not present")
+
+// Valid reports whether c can be sent in a Set-Cookie field as is.
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("invalid cookie name %q", c.Name)
+	}
+	if _, ok := unquote(c.Value); !ok {
+		return fmt.Errorf("invalid value for cookie %s", c.Name)
+	}
+	if !isAttributeValue(c.Path) {
+		return fmt.Errorf("invalid path for cookie %s", c.Name)
+	}
+	if !isAttributeValue(c.Domain) || strings.ContainsAny(c.Domain, " \t") {
+		return fmt.Errorf("invalid domain for cookie %s", c.Name)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("expires of cookie %s is before 1601", c.Name)
+	}
+	if c.SameSite < SameSiteDefault || c.SameSite > SameSiteNone {
+		return fmt.Errorf("invalid SameSite for cookie %s", c.Name)
+	}
+	// user agents drop these unless the cookie is also Secure
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie %s is SameSite=None but not Secure", c.Name)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("cookie %s is Partitioned but not Secure", c.Name)
+	}
+	return nil
+}
+
+// String returns c serialized as a Set-Cookie field value. It does not check
+// that c is valid, see Valid.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		// a leading dot is ignored by user agents, RFC 6265 section 5.2.3
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(headers.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse parses the value of a request's Cookie field, a list of name=value
+// pairs separated by "; ". Malformed pairs are skipped rather than failing
+// the whole field, user agents are known to send them.
+func Parse(value string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(value, ";") {
+		name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !isToken(name) {
+			continue
+		}
+		val, ok := unquote(val)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: val})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses a Set-Cookie field value. Unknown attributes are
+// ignored, as are known ones with a value that does not parse.
+func ParseSetCookie(value string) (*Cookie, error) {
+	parts := strings.Split(value, ";")
+	name, val, found := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !found || !isToken(name) {
+		return nil, fmt.Errorf("invalid cookie-pair %q", parts[0])
+	}
+	val, ok := unquote(val)
+	if !ok {
+		return nil, fmt.Errorf("invalid value for cookie %s", name)
+	}
+	c := &Cookie{Name: name, Value: val}
+	for _, attr := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch strings.ToLower(key) {
+		case "path":
+			c.Path = val
+		case "domain":
+			c.Domain = strings.TrimPrefix(val, ".")
+		case "expires":
+			t, err := headers.ParseTime(val)
+			if err == nil {
+				c.Expires = t
+			}
+		case "max-age":
+			seconds, err := strconv.Atoi(val)
+			if err != nil {
+				break
+			}
+			if seconds <= 0 {
+				seconds = -1
+			}
+			c.MaxAge = seconds
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c, nil
+}
+
+// unquote strips the optional double quotes around a cookie-value and
+// reports whether what is left is made of cookie-octets only.
+func unquote(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		if !isCookieOctet(value[i]) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// isCookieOctet reports whether b may appear in a cookie-value, which is any
+// visible US-ASCII character but DQUOTE, comma, semicolon and backslash.
+func isCookieOctet(b byte) bool {
+	return b > ' ' && b < 0x7f && b != '"' && b != ',' && b != ';' && b != '\\'
+}
+
+// isAttributeValue reports whether s can be used as the value of a Path or
+// Domain attribute, which can't hold control characters or a semicolon.
+func isAttributeValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == 0x7f || s[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
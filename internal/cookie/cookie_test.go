@@ -0,0 +1,70 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieString(t *testing.T) {
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	parsed, err := ParseSetCookie(c.String())
+	require.NoError(t, err)
+	c.Domain = "example.com"
+	assert.Equal(t, c, parsed)
+
+	c = &Cookie{Name: "gone", Value: "", MaxAge: -1}
+	assert.Equal(t, "gone=; Max-Age=0", c.String())
+}
+
+func TestCookieValid(t *testing.T) {
+	cases := []struct {
+		name   string
+		cookie Cookie
+	}{
+		{"empty name", Cookie{Value: "x"}},
+		{"separator in name", Cookie{Name: "a b", Value: "x"}},
+		{"semicolon in value", Cookie{Name: "a", Value: "x;y"}},
+		{"comma in value", Cookie{Name: "a", Value: "x,y"}},
+		{"space in value", Cookie{Name: "a", Value: "x y"}},
+		{"control character in path", Cookie{Name: "a", Value: "x", Path: "/\r\nX-Injected: 1"}},
+		{"semicolon in domain", Cookie{Name: "a", Value: "x", Domain: "example.com; Secure"}},
+		{"expires before 1601", Cookie{Name: "a", Value: "x", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{"SameSite=None without Secure", Cookie{Name: "a", Value: "x", SameSite: SameSiteNone}},
+		{"Partitioned without Secure", Cookie{Name: "a", Value: "x", Partitioned: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Error(t, tc.cookie.Valid())
+		})
+	}
+
+	c := Cookie{Name: "a", Value: `"quoted"`}
+	assert.NoError(t, c.Valid())
+}
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`a=1; b="two";bad pair; =nameless; c=3`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, &Cookie{Name: "a", Value: "1"}, cookies[0])
+	assert.Equal(t, &Cookie{Name: "b", Value: "two"}, cookies[1])
+	assert.Equal(t, &Cookie{Name: "c", Value: "3"}, cookies[2])
+
+	assert.Empty(t, Parse(""))
+}
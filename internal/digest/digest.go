@@ -0,0 +1,135 @@
+// Package digest computes and checks the Content-Digest and Repr-Digest
+// fields of RFC 9530, which carry hashes of a message's content or of its
+// selected representation.
+package digest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"http-from-tcp/internal/headers"
+	"strings"
+)
+
+// Algorithm is a hash algorithm from the HTTP Digest Algorithm Values
+// registry, as it is named in the fields.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha-256"
+	SHA512 Algorithm = "sha-512"
+)
+
+// Algorithms are the algorithms this package supports. The others in the
+// registry are deprecated, insecure ones.
+var Algorithms = []Algorithm{SHA256, SHA512}
+
+var ErrMismatch = errors.New("digest mismatch")
+
+func (a Algorithm) new() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New()
+	case SHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+// Hash computes the digest of the bytes written to it with one or more
+// algorithms at once.
+type Hash struct {
+	algorithms []Algorithm
+	hashes     []hash.Hash
+}
+
+// New returns a Hash for algorithms, or all of Algorithms if there are
+// none. It fails if one of them is not supported.
+func New(algorithms ...Algorithm) (*Hash, error) {
+	if len(algorithms) == 0 {
+		algorithms = Algorithms
+	}
+	h := &Hash{}
+	for _, a := range algorithms {
+		a = Algorithm(strings.ToLower(string(a)))
+		hash := a.new()
+		if hash == nil {
+			return nil, fmt.Errorf("unsupported digest algorithm %q", a)
+		}
+		h.algorithms = append(h.algorithms, a)
+		h.hashes = append(h.hashes, hash)
+	}
+	return h, nil
+}
+
+func (h *Hash) Write(p []byte) (int, error) {
+	for _, hash := range h.hashes {
+		hash.Write(p)
+	}
+	return len(p), nil
+}
+
+// Sum returns the digest computed with algorithm so far, nil if the Hash
+// doesn't use it.
+func (h *Hash) Sum(algorithm Algorithm) []byte {
+	for i, a := range h.algorithms {
+		if a == algorithm {
+			return h.hashes[i].Sum(nil)
+		}
+	}
+	return nil
+}
+
+// Field returns the digests computed so far as a Content-Digest or
+// Repr-Digest field value, `sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:`
+// for "hello".
+func (h *Hash) Field() string {
+	dict := headers.Dictionary{}
+	for _, a := range h.algorithms {
+		dict = append(dict, headers.DictMember{Key: string(a), Value: headers.Item{Value: h.Sum(a)}})
+	}
+	// byte sequences and lower-case keys always serialize
+	value, _ := headers.FormatDictionary(dict)
+	return value
+}
+
+// Verify checks the digests in value, a Content-Digest or Repr-Digest field
+// value, against those computed so far. Digests with algorithms the Hash
+// doesn't use are ignored, as is a value that is not a valid field: RFC
+// 9530 leaves it to the recipient what to do with digests it can't check.
+func (h *Hash) Verify(value string) error {
+	digests, err := Parse(value)
+	if err != nil {
+		return nil
+	}
+	for a, digest := range digests {
+		sum := h.Sum(a)
+		if sum != nil && !bytes.Equal(sum, digest) {
+			return fmt.Errorf("%w: %s", ErrMismatch, a)
+		}
+	}
+	return nil
+}
+
+// Parse returns the digests in value, a Content-Digest or Repr-Digest field
+// value, by algorithm. Members that are not byte sequences are left out.
+func Parse(value string) (map[Algorithm][]byte, error) {
+	dict, err := headers.ParseDictionary(value)
+	if err != nil {
+		return nil, err
+	}
+	digests := map[Algorithm][]byte{}
+	for _, member := range dict {
+		item, ok := member.Value.(headers.Item)
+		if !ok {
+			continue
+		}
+		if digest, ok := item.Value.([]byte); ok {
+			digests[Algorithm(member.Key)] = digest
+		}
+	}
+	return digests, nil
+}
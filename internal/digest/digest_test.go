@@ -0,0 +1,71 @@
+package digest
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	helloSHA256 = "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:"
+	helloSHA512 = "sha-512=:m3HSJL1i83hdltRq0+o9czGb+8KJDKra4t/3JRlnPKcjI8PZm6XBHXx6zG4UuMXaDEZjR1wuXDre9G9zvN7AQw==:"
+)
+
+func TestField(t *testing.T) {
+	// Test: Digests of the same bytes written in pieces
+	h, err := New()
+	require.NoError(t, err)
+	io.WriteString(h, "hel")
+	io.WriteString(h, "lo")
+	assert.Equal(t, helloSHA256+", "+helloSHA512, h.Field())
+
+	h, err = New(SHA512)
+	require.NoError(t, err)
+	io.WriteString(h, "hello")
+	assert.Equal(t, helloSHA512, h.Field())
+	assert.Nil(t, h.Sum(SHA256))
+
+	// Test: Unsupported algorithms
+	_, err = New("md5")
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	h, err := New(SHA256)
+	require.NoError(t, err)
+	io.WriteString(h, "hello")
+
+	for _, tc := range []struct {
+		name  string
+		value string
+		err   bool
+	}{
+		{"match", helloSHA256, false},
+		{"match among others", "md5=:XUFAKrxLKna5cZ2REBfFkg==:, " + helloSHA256, false},
+		{"mismatch", "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", true},
+		{"other algorithms only", helloSHA512, false},
+		{"not a dictionary", "sha-256=:not base64", false},
+		{"not a byte sequence", "sha-256=abc", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := h.Verify(tc.value)
+			if tc.err {
+				assert.ErrorIs(t, err, ErrMismatch)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	digests, err := Parse(helloSHA256 + ", unixsum=30637")
+	require.NoError(t, err)
+	assert.Len(t, digests, 1)
+	assert.Len(t, digests[SHA256], 32)
+
+	_, err = Parse("sha-256=:abc")
+	assert.Error(t, err)
+}
@@ -2,68 +2,279 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"strings"
 )
 
-type Headers map[string]string
+// Field is a single field line. Name keeps the casing it was sent or added
+// with.
+type Field struct {
+	Name  string
+	Value string
+	raw   string // the line as it was parsed, without CRLF, empty if added
+}
+
+// Headers is an ordered list of fields. Field names are matched
+// case-insensitively and repeated fields are kept as separate entries, in
+// the order they were added. A nil *Headers reads as empty, but fields can
+// only be added to one made with NewHeaders.
+type Headers struct {
+	fields []Field
+}
 
 const CRLF = "\r\n"
 
-func NewHeaders() (Headers) {
-	return make(Headers)
+var (
+	ErrInvalidFieldName  = errors.New("invalid field name")
+	ErrInvalidFieldValue = errors.New("invalid field value")
+)
+
+// ObsTextPolicy decides what to do with obs-text, the bytes 0x80 to 0xFF, in
+// field values. RFC 9110 only keeps them for compatibility with old
+// ISO-8859-1 values, and they are treated as opaque data when allowed.
+type ObsTextPolicy int
+
+const (
+	ObsTextAllow ObsTextPolicy = iota
+	ObsTextReject
+)
+
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
-func (h Headers) Get(fieldName string) string {
-	return h[strings.ToLower(fieldName)]
+// Get returns the combined value of all fields named fieldName, joined with
+// ", " in order, or "" if there are none. Set-Cookie values can't be
+// combined, the dates in them hold commas, so for it Get returns the first
+// value only and Values has to be used to see the rest.
+func (h *Headers) Get(fieldName string) string {
+	values := h.Values(fieldName)
+	if len(values) > 0 && strings.EqualFold(fieldName, "Set-Cookie") {
+		return values[0]
+	}
+	return strings.Join(values, ", ")
 }
 
-func (h Headers) Set(fieldName string, value string) {
-	key := strings.ToLower(fieldName)
-	if _, found := h[key]; found {
-		h[key] = fmt.Sprintf("%s, %s", h[key], value);
-	} else {
-		h[key] = value
+// Values returns the values of all fields named fieldName, in order.
+func (h *Headers) Values(fieldName string) []string {
+	if h == nil {
+		return nil
+	}
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, fieldName) {
+			values = append(values, f.Value)
+		}
 	}
+	return values
+}
+
+// Add appends a field, keeping any existing ones of the same name.
+func (h *Headers) Add(fieldName string, value string) {
+	h.fields = append(h.fields, Field{Name: fieldName, Value: value})
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// Set replaces all fields named fieldName with a single one. It takes the
+// place of the first field it replaces, or is appended if there was none.
+func (h *Headers) Set(fieldName string, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.Name, fieldName) {
+			h.fields[i] = Field{Name: fieldName, Value: value}
+			h.del(fieldName, i+1)
+			return
+		}
+	}
+	h.Add(fieldName, value)
+}
+
+func (h *Headers) Has(fieldName string) bool {
+	if h == nil {
+		return false
+	}
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, fieldName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Headers) Del(fieldName string) {
+	if h == nil {
+		return
+	}
+	h.del(fieldName, 0)
+}
+
+// del removes the fields named fieldName at or after index from.
+func (h *Headers) del(fieldName string, from int) {
+	kept := h.fields[:from]
+	for _, f := range h.fields[from:] {
+		if !strings.EqualFold(f.Name, fieldName) {
+			kept = append(kept, f)
+		}
+	}
+	clear(h.fields[len(kept):])
+	h.fields = kept
+}
+
+// Len returns the number of fields, counting repeated ones separately.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// All yields every field in order, repeated ones separately.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(f.Name, f.Value) {
+				return
+			}
+		}
+	}
+}
+
+// HasToken reports whether the comma separated value of fieldName contains
+// token. Tokens are compared case-insensitively.
+func (h *Headers) HasToken(fieldName string, token string) bool {
+	for _, t := range h.Tokens(fieldName) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the fields in order, without the empty line ending the field
+// section. Parsed fields are written back exactly as they were received,
+// added ones as "Name: Value". Added fields are validated first, obs-text
+// allowed, and nothing is written if one of them is invalid: a CR or LF in a
+// value would otherwise end the field line and start another.
+func (h *Headers) Write(w io.Writer) error {
+	if h == nil {
+		return nil
+	}
+	var b strings.Builder
+	for _, f := range h.fields {
+		if f.raw != "" {
+			b.WriteString(f.raw)
+		} else {
+			if err := ValidateField(f.Name, f.Value, ObsTextAllow); err != nil {
+				return err
+			}
+			b.WriteString(f.Name + ": " + f.Value)
+		}
+		b.WriteString(CRLF)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	fieldName, fieldValue, n, done, err := ParseFieldLine(data)
+	if err != nil || n == 0 || done {
+		return n, done, err
+	}
+	h.fields = append(h.fields, Field{Name: fieldName, Value: fieldValue, raw: string(data[:n-len(CRLF)])})
+	return n, false, nil
+}
+
+// CanonicalName returns fieldName with the first letter and every letter
+// following a hyphen upper-cased and the rest lower-cased, so
+// "content-TYPE" becomes "Content-Type".
+func CanonicalName(fieldName string) string {
+	b := []byte(fieldName)
+	upper := true
+	for i, c := range b {
+		switch {
+		case upper && 'a' <= c && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		case !upper && 'A' <= c && c <= 'Z':
+			b[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// ParseFieldLine parses the field line at the start of data without storing
+// it anywhere. n is 0 if data does not hold a complete line yet, and done
+// is set when the line is the empty one ending the field section.
+func ParseFieldLine(data []byte) (fieldName string, fieldValue string, n int, done bool, err error) {
 	ind := bytes.Index(data, []byte(CRLF))
 	if ind == 0 {
-		return 2, true, nil
+		return "", "", 2, true, nil
 	}
 	if ind == -1 {
-		return 0, false, nil
+		return "", "", 0, false, nil
 	}
 
-	sepIndex := bytes.IndexByte(data, ':')
+	line := data[:ind]
+	if bytes.ContainsAny(line, "\r\n") {
+		return "", "", 0, false, fmt.Errorf("bare CR or LF in field line")
+	}
+	sepIndex := bytes.IndexByte(line, ':')
 	if sepIndex == -1 {
-		return 0, false, fmt.Errorf("header does not contain separator")
+		return "", "", 0, false, fmt.Errorf("header does not contain separator")
 	}
 	if sepIndex == 0 {
-		return 0, false, fmt.Errorf("header contains no field-name")
+		return "", "", 0, false, fmt.Errorf("header contains no field-name")
 	}
-	if data[sepIndex - 1] == ' ' {
-		return 0, false, fmt.Errorf("whitespace not allowed between field-name and separator")
+	if line[sepIndex - 1] == ' ' || line[sepIndex - 1] == '\t' {
+		return "", "", 0, false, fmt.Errorf("whitespace not allowed between field-name and separator")
 	}
-	fieldName := data[:sepIndex]
-	fieldName = bytes.TrimSpace(fieldName)
-	if !isValidFieldName(fieldName) {
-		return 0, false, fmt.Errorf("invalid character in field-name")
+	name := bytes.TrimSpace(line[:sepIndex])
+	if len(name) == 0 || !isValidFieldName(name) {
+		return "", "", 0, false, fmt.Errorf("invalid character in field-name")
 	}
 
-	fieldValue := data[(sepIndex + 1):ind]
-	fieldValue = bytes.TrimSpace(fieldValue)
+	value := bytes.TrimSpace(line[(sepIndex + 1):])
+	if err := ValidateValue(string(value), ObsTextAllow); err != nil {
+		return "", "", 0, false, err
+	}
 
-	h.Set(string(fieldName), string(fieldValue))
+	// fmt.Printf("found %s: %s\n", string(name), string(value))
 
-  // if (bytes.Index(data[(ind + 2):], []byte(CRLF)) == 0) {
-  //   return ind + 2, true, nil
-  // }
+	return string(name), string(value), ind + 2, false, nil
+}
 
-  // fmt.Printf("found %s: %s\n", string(fieldName), string(fieldValue))
+// ValidateField checks that a field can be written as name: value without
+// changing the meaning of the message around it.
+func ValidateField(name string, value string, policy ObsTextPolicy) error {
+	if name == "" || !isValidFieldName([]byte(name)) {
+		return fmt.Errorf("%w: %q", ErrInvalidFieldName, name)
+	}
+	if err := ValidateValue(value, policy); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
 
-	return ind + 2, false, nil
+// ValidateValue checks value against the field-value grammar of RFC 9110
+// section 5.5: visible characters, spaces and tabs, and obs-text if policy
+// allows it. CR, LF, NUL and every other control character are rejected.
+func ValidateValue(value string, policy ObsTextPolicy) error {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\t' || (c >= ' ' && c < 0x7f):
+		case c >= 0x80 && policy == ObsTextAllow:
+		case c >= 0x80:
+			return fmt.Errorf("%w: obs-text at offset %d", ErrInvalidFieldValue, i)
+		default:
+			return fmt.Errorf("%w: control character %#02x at offset %d", ErrInvalidFieldValue, c, i)
+		}
+	}
+	return nil
 }
 
 func isValidFieldName(fieldName []byte) bool {
@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -68,4 +69,109 @@ func TestHeaderParsing(t *testing.T) {
 	data = data[n:]
 	n, done, err = headers.Parse(data)
 	assert.Equal(t, "localhost:1, localhost:2, localhost:3", headers.Get("Host"))
-}
\ No newline at end of file
+
+	// Test: Tab before the separator
+	headers = NewHeaders()
+	data = []byte("Host\t: localhost:42069\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+
+	// Test: Bare LF inside a field line
+	headers = NewHeaders()
+	data = []byte("Host: localhost\nX-Smuggled: yes\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+}
+
+func TestHeadersMultipleValues(t *testing.T) {
+	h := NewHeaders()
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Content-Type", "text/plain")
+	h.Add("set-cookie", "b=2")
+	assert.Equal(t, []string{"a=1", "b=2"}, h.Values("SET-COOKIE"))
+	// Set-Cookie values are never combined, the dates in them hold commas
+	assert.Equal(t, "a=1", h.Get("Set-Cookie"))
+	h.Add("Accept", "text/plain")
+	h.Add("accept", "text/html")
+	assert.Equal(t, "text/plain, text/html", h.Get("Accept"))
+	h.Del("Accept")
+	assert.Equal(t, 3, h.Len())
+
+	// Set replaces every value, in the position of the first one
+	h.Set("Set-Cookie", "c=3")
+	var names, values []string
+	for name, value := range h.All() {
+		names = append(names, name)
+		values = append(values, value)
+	}
+	assert.Equal(t, []string{"Set-Cookie", "Content-Type"}, names)
+	assert.Equal(t, []string{"c=3", "text/plain"}, values)
+
+	h.Del("content-type")
+	assert.False(t, h.Has("Content-Type"))
+	assert.Nil(t, h.Values("Content-Type"))
+	assert.Equal(t, 1, h.Len())
+
+	var nilHeaders *Headers
+	assert.Equal(t, "", nilHeaders.Get("Host"))
+	assert.Equal(t, 0, nilHeaders.Len())
+}
+
+func TestHeadersRoundTrip(t *testing.T) {
+	data := "Host:localhost:42069\r\nx-custom-HEADER:  a\r\nAccept: */*\r\nx-custom-header: b\r\n"
+	h := NewHeaders()
+	for rest := []byte(data + "\r\n"); ; {
+		n, done, err := h.Parse(rest)
+		require.NoError(t, err)
+		if done {
+			break
+		}
+		rest = rest[n:]
+	}
+
+	var b strings.Builder
+	require.NoError(t, h.Write(&b))
+	assert.Equal(t, data, b.String())
+
+	h.Add("x-added", "c")
+	b.Reset()
+	require.NoError(t, h.Write(&b))
+	assert.Equal(t, data+"x-added: c\r\n", b.String())
+
+	// Test: An invalid added field fails the whole write
+	h.Add("X-Evil", "a\r\nSet-Cookie: evil=1")
+	b.Reset()
+	assert.ErrorIs(t, h.Write(&b), ErrInvalidFieldValue)
+	assert.Empty(t, b.String())
+	h = NewHeaders()
+	h.Add("X Evil", "a")
+	assert.ErrorIs(t, h.Write(&b), ErrInvalidFieldName)
+	assert.Empty(t, b.String())
+}
+
+func TestCanonicalName(t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalName("content-TYPE"))
+	assert.Equal(t, "X-Custom-Header", CanonicalName("x-custom-header"))
+	assert.Equal(t, "Host", CanonicalName("HOST"))
+	assert.Equal(t, "Www-Authenticate", CanonicalName("WWW-Authenticate"))
+}
+
+func TestValidateField(t *testing.T) {
+	assert.NoError(t, ValidateField("X-Test", "a value\twith a tab", ObsTextReject))
+	assert.NoError(t, ValidateField("X-Test", "", ObsTextReject))
+	assert.NoError(t, ValidateField("X-Test", "caf\xe9", ObsTextAllow))
+
+	assert.ErrorIs(t, ValidateField("X-Test", "caf\xe9", ObsTextReject), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X-Test", "a\r\nSet-Cookie: evil=1", ObsTextAllow), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X-Test", "a\nb", ObsTextAllow), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X-Test", "a\x00b", ObsTextAllow), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X-Test", "a\x7fb", ObsTextAllow), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X Test", "a", ObsTextAllow), ErrInvalidFieldName)
+	assert.ErrorIs(t, ValidateField("X-Test:", "a", ObsTextAllow), ErrInvalidFieldName)
+	assert.ErrorIs(t, ValidateField("", "a", ObsTextAllow), ErrInvalidFieldName)
+
+	// Test: Control characters in a parsed value
+	h := NewHeaders()
+	_, _, err := h.Parse([]byte("X-Test: a\x00b\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidFieldValue)
+}
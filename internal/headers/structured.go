@@ -0,0 +1,716 @@
+package headers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Structured Field Values, RFC 9651 (which obsoletes RFC 8941 and adds
+// dates and display strings).
+//
+// Bare item values are held as:
+//
+//	Integer         int64
+//	Decimal         float64
+//	String          string
+//	Token           Token
+//	Byte Sequence   []byte
+//	Boolean         bool
+//	Date            time.Time, whole seconds
+//	Display String  DisplayString
+
+var ErrInvalidStructuredField = errors.New("invalid structured field")
+
+// Token is a bare item of type Token, as opposed to String.
+type Token string
+
+// DisplayString is a bare item of type Display String, Unicode text that is
+// percent-encoded on the wire.
+type DisplayString string
+
+// Param is a parameter, a key and a bare item value.
+type Param struct {
+	Key   string
+	Value any
+}
+
+// Params is an ordered list of parameters with unique keys.
+type Params []Param
+
+// Get returns the value of the parameter key, or nil if there is none.
+func (p Params) Get(key string) any {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value
+		}
+	}
+	return nil
+}
+
+// set sets key to value, keeping the position of the key if it was
+// already there as RFC 9651 asks of parsers.
+func (p Params) set(key string, value any) Params {
+	for i := range p {
+		if p[i].Key == key {
+			p[i].Value = value
+			return p
+		}
+	}
+	return append(p, Param{Key: key, Value: value})
+}
+
+// Member is a member of a List or Dictionary, either an Item or an
+// InnerList.
+type Member interface {
+	member()
+}
+
+type Item struct {
+	Value  any
+	Params Params
+}
+
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+func (Item) member()      {}
+func (InnerList) member() {}
+
+type List []Member
+
+// DictMember is a member of a Dictionary.
+type DictMember struct {
+	Key   string
+	Value Member
+}
+
+// Dictionary is an ordered list of members with unique keys.
+type Dictionary []DictMember
+
+// Get returns the member with the given key, or nil if there is none.
+func (d Dictionary) Get(key string) Member {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Value
+		}
+	}
+	return nil
+}
+
+// Item parses the fields called fieldName as a structured Item. Like the
+// other structured accessors it parses the combined value of all the fields
+// of that name.
+func (h *Headers) Item(fieldName string) (Item, error) {
+	return ParseItem(h.Get(fieldName))
+}
+
+// List parses the fields called fieldName as a structured List. A missing
+// field is an empty List.
+func (h *Headers) List(fieldName string) (List, error) {
+	return ParseList(h.Get(fieldName))
+}
+
+// Dictionary parses the fields called fieldName as a structured Dictionary.
+// A missing field is an empty Dictionary.
+func (h *Headers) Dictionary(fieldName string) (Dictionary, error) {
+	return ParseDictionary(h.Get(fieldName))
+}
+
+func ParseItem(s string) (Item, error) {
+	p := &sfParser{s: s}
+	p.skipSP()
+	item, err := p.item()
+	if err != nil {
+		return Item{}, err
+	}
+	return item, p.end()
+}
+
+func ParseList(s string) (List, error) {
+	p := &sfParser{s: s}
+	p.skipSP()
+	list := List{}
+	for !p.empty() {
+		member, err := p.member()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, member)
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return list, p.end()
+}
+
+func ParseDictionary(s string) (Dictionary, error) {
+	p := &sfParser{s: s}
+	p.skipSP()
+	dict := Dictionary{}
+	for !p.empty() {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var member Member
+		if p.consume('=') {
+			member, err = p.member()
+		} else {
+			var params Params
+			params, err = p.params()
+			member = Item{Value: true, Params: params}
+		}
+		if err != nil {
+			return nil, err
+		}
+		dict = dict.set(key, member)
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return dict, p.end()
+}
+
+func (d Dictionary) set(key string, member Member) Dictionary {
+	for i := range d {
+		if d[i].Key == key {
+			d[i].Value = member
+			return d
+		}
+	}
+	return append(d, DictMember{Key: key, Value: member})
+}
+
+// sfParser implements the parsing algorithms of RFC 9651 section 4.2 over
+// s, consuming it from the front.
+type sfParser struct {
+	s string
+}
+
+func (p *sfParser) empty() bool {
+	return p.s == ""
+}
+
+func (p *sfParser) peek() byte {
+	if p.s == "" {
+		return 0
+	}
+	return p.s[0]
+}
+
+func (p *sfParser) consume(c byte) bool {
+	if p.s == "" || p.s[0] != c {
+		return false
+	}
+	p.s = p.s[1:]
+	return true
+}
+
+func (p *sfParser) skipSP() {
+	p.s = strings.TrimLeft(p.s, " ")
+}
+
+func (p *sfParser) skipOWS() {
+	p.s = strings.TrimLeft(p.s, " \t")
+}
+
+func (p *sfParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidStructuredField, fmt.Sprintf(format, args...))
+}
+
+// end checks nothing but spaces is left once the top-level value is parsed.
+func (p *sfParser) end() error {
+	p.skipSP()
+	if !p.empty() {
+		return p.errorf("trailing characters %q", p.s)
+	}
+	return nil
+}
+
+// next moves past the comma between two List or Dictionary members.
+func (p *sfParser) next() error {
+	p.skipOWS()
+	if p.empty() {
+		return nil
+	}
+	if !p.consume(',') {
+		return p.errorf("expected a comma, found %q", p.s)
+	}
+	p.skipOWS()
+	if p.empty() {
+		return p.errorf("trailing comma")
+	}
+	return nil
+}
+
+func (p *sfParser) member() (Member, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *sfParser) innerList() (InnerList, error) {
+	p.consume('(')
+	list := InnerList{Items: []Item{}}
+	for !p.empty() {
+		p.skipSP()
+		if p.consume(')') {
+			params, err := p.params()
+			list.Params = params
+			return list, err
+		}
+		item, err := p.item()
+		if err != nil {
+			return InnerList{}, err
+		}
+		list.Items = append(list.Items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return InnerList{}, p.errorf("expected a space or ')' in inner list")
+		}
+	}
+	return InnerList{}, p.errorf("unterminated inner list")
+}
+
+func (p *sfParser) item() (Item, error) {
+	value, err := p.bareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	params, err := p.params()
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: value, Params: params}, nil
+}
+
+func (p *sfParser) params() (Params, error) {
+	params := Params{}
+	for p.consume(';') {
+		p.skipSP()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var value any = true
+		if p.consume('=') {
+			value, err = p.bareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		params = params.set(key, value)
+	}
+	return params, nil
+}
+
+func (p *sfParser) key() (string, error) {
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.errorf("invalid key %q", p.s)
+	}
+	i := 1
+	for i < len(p.s) && isKeyChar(p.s[i]) {
+		i++
+	}
+	key := p.s[:i]
+	p.s = p.s[i:]
+	return key, nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == '*' || isAlpha(c):
+		return p.token(), nil
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		return p.boolean()
+	case c == '@':
+		return p.date()
+	case c == '%':
+		return p.displayString()
+	}
+	return nil, p.errorf("unknown bare item %q", p.s)
+}
+
+func (p *sfParser) number() (any, error) {
+	i := 0
+	if p.peek() == '-' {
+		i++
+	}
+	if i == len(p.s) || !isDigit(p.s[i]) {
+		return nil, p.errorf("number without digits")
+	}
+	start := i
+	decimal := false
+	for ; i < len(p.s); i++ {
+		c := p.s[i]
+		if isDigit(c) {
+			continue
+		}
+		if c == '.' && !decimal {
+			if i-start > 12 {
+				return nil, p.errorf("decimal integer part too long")
+			}
+			decimal = true
+			continue
+		}
+		break
+	}
+	num := p.s[:i]
+	if !decimal && i-start > 15 {
+		return nil, p.errorf("integer too long")
+	}
+	if decimal && i-start > 16 {
+		return nil, p.errorf("decimal too long")
+	}
+	p.s = p.s[i:]
+	if !decimal {
+		return strconv.ParseInt(num, 10, 64)
+	}
+	if strings.HasSuffix(num, ".") {
+		return nil, p.errorf("decimal ends with '.'")
+	}
+	if _, frac, _ := strings.Cut(num, "."); len(frac) > 3 {
+		return nil, p.errorf("decimal with more than three fractional digits")
+	}
+	return strconv.ParseFloat(num, 64)
+}
+
+func (p *sfParser) string() (string, error) {
+	p.consume('"')
+	var b strings.Builder
+	for i := 0; i < len(p.s); i++ {
+		c := p.s[i]
+		switch {
+		case c == '\\':
+			i++
+			if i == len(p.s) || (p.s[i] != '"' && p.s[i] != '\\') {
+				return "", p.errorf("invalid escape in string")
+			}
+			b.WriteByte(p.s[i])
+		case c == '"':
+			p.s = p.s[i+1:]
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character %#02x in string", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *sfParser) token() Token {
+	i := 1
+	for i < len(p.s) && isTokenChar(p.s[i]) {
+		i++
+	}
+	token := p.s[:i]
+	p.s = p.s[i:]
+	return Token(token)
+}
+
+func (p *sfParser) byteSequence() ([]byte, error) {
+	p.consume(':')
+	end := strings.IndexByte(p.s, ':')
+	if end == -1 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	encoded := p.s[:end]
+	p.s = p.s[end+1:]
+	for i := 0; i < len(encoded); i++ {
+		if c := encoded[i]; !isAlpha(c) && !isDigit(c) && c != '+' && c != '/' && c != '=' {
+			return nil, p.errorf("invalid character %q in byte sequence", c)
+		}
+	}
+	// padding is optional for parsers
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, p.errorf("byte sequence: %v", err)
+	}
+	return data, nil
+}
+
+func (p *sfParser) boolean() (bool, error) {
+	p.consume('?')
+	switch {
+	case p.consume('1'):
+		return true, nil
+	case p.consume('0'):
+		return false, nil
+	}
+	return false, p.errorf("invalid boolean")
+}
+
+func (p *sfParser) date() (time.Time, error) {
+	p.consume('@')
+	num, err := p.number()
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, ok := num.(int64)
+	if !ok {
+		return time.Time{}, p.errorf("date is not an integer")
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+func (p *sfParser) displayString() (DisplayString, error) {
+	p.consume('%')
+	if !p.consume('"') {
+		return "", p.errorf("display string without '\"'")
+	}
+	var b []byte
+	for i := 0; i < len(p.s); i++ {
+		c := p.s[i]
+		switch {
+		case c == '%':
+			if i+2 >= len(p.s) || !isLCHex(p.s[i+1]) || !isLCHex(p.s[i+2]) {
+				return "", p.errorf("invalid percent-encoding in display string")
+			}
+			octet, _ := strconv.ParseUint(p.s[i+1:i+3], 16, 8)
+			b = append(b, byte(octet))
+			i += 2
+		case c == '"':
+			p.s = p.s[i+1:]
+			if !utf8.Valid(b) {
+				return "", p.errorf("display string is not valid UTF-8")
+			}
+			return DisplayString(b), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character %#02x in display string", c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", p.errorf("unterminated display string")
+}
+
+// FormatItem serializes item, failing if any part of it can't be
+// represented, an integer out of range or a string with non-ASCII
+// characters for instance.
+func FormatItem(item Item) (string, error) {
+	var b strings.Builder
+	err := formatItem(&b, item)
+	return b.String(), err
+}
+
+// FormatList serializes list. An empty List serializes to "", and the field
+// should be left out altogether.
+func FormatList(list List) (string, error) {
+	var b strings.Builder
+	for i, member := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := formatMember(&b, member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// FormatDictionary serializes dict. An empty Dictionary serializes to "",
+// and the field should be left out altogether.
+func FormatDictionary(dict Dictionary) (string, error) {
+	var b strings.Builder
+	for i, m := range dict {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := formatKey(&b, m.Key); err != nil {
+			return "", err
+		}
+		// members that are true are sent as just their key
+		if item, ok := m.Value.(Item); ok && item.Value == true {
+			if err := formatParams(&b, item.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte('=')
+		if err := formatMember(&b, m.Value); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func formatMember(b *strings.Builder, member Member) error {
+	switch m := member.(type) {
+	case Item:
+		return formatItem(b, m)
+	case InnerList:
+		b.WriteByte('(')
+		for i, item := range m.Items {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			if err := formatItem(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+		return formatParams(b, m.Params)
+	}
+	return fmt.Errorf("%w: unknown member type %T", ErrInvalidStructuredField, member)
+}
+
+func formatItem(b *strings.Builder, item Item) error {
+	if err := formatBareItem(b, item.Value); err != nil {
+		return err
+	}
+	return formatParams(b, item.Params)
+}
+
+func formatParams(b *strings.Builder, params Params) error {
+	for _, param := range params {
+		b.WriteByte(';')
+		if err := formatKey(b, param.Key); err != nil {
+			return err
+		}
+		if param.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := formatBareItem(b, param.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatKey(b *strings.Builder, key string) error {
+	if key == "" || (!isLCAlpha(key[0]) && key[0] != '*') {
+		return fmt.Errorf("%w: invalid key %q", ErrInvalidStructuredField, key)
+	}
+	for i := 1; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return fmt.Errorf("%w: invalid key %q", ErrInvalidStructuredField, key)
+		}
+	}
+	b.WriteString(key)
+	return nil
+}
+
+// maxInteger bounds the integers a structured field can carry.
+const maxInteger = 999_999_999_999_999
+
+func formatBareItem(b *strings.Builder, value any) error {
+	switch v := value.(type) {
+	case int:
+		return formatBareItem(b, int64(v))
+	case int64:
+		if v > maxInteger || v < -maxInteger {
+			return fmt.Errorf("%w: integer %d out of range", ErrInvalidStructuredField, v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		return formatDecimal(b, v)
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("%w: invalid character %#02x in string", ErrInvalidStructuredField, c)
+			}
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	case Token:
+		if v == "" || (v[0] != '*' && !isAlpha(v[0])) {
+			return fmt.Errorf("%w: invalid token %q", ErrInvalidStructuredField, v)
+		}
+		for i := 1; i < len(v); i++ {
+			if !isTokenChar(v[i]) {
+				return fmt.Errorf("%w: invalid token %q", ErrInvalidStructuredField, v)
+			}
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteString(":" + base64.StdEncoding.EncodeToString(v) + ":")
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	case time.Time:
+		b.WriteByte('@')
+		return formatBareItem(b, v.Unix())
+	case DisplayString:
+		if !utf8.ValidString(string(v)) {
+			return fmt.Errorf("%w: display string is not valid UTF-8", ErrInvalidStructuredField)
+		}
+		b.WriteString(`%"`)
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c == '%' || c == '"' || c < 0x20 || c > 0x7e {
+				fmt.Fprintf(b, "%%%02x", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+	default:
+		return fmt.Errorf("%w: unsupported bare item type %T", ErrInvalidStructuredField, value)
+	}
+	return nil
+}
+
+func formatDecimal(b *strings.Builder, v float64) error {
+	// rounded to three decimal places, ties to even
+	v = math.RoundToEven(v*1000) / 1000
+	if math.IsNaN(v) || math.Abs(v) >= 1e12 {
+		return fmt.Errorf("%w: decimal %v out of range", ErrInvalidStructuredField, v)
+	}
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	b.WriteString(s)
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isLCAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isLCHex(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f')
+}
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
+
+// isTokenChar reports whether c may follow the first character of a Token,
+// a tchar or one of ':' and '/'.
+func isTokenChar(c byte) bool {
+	return c == ':' || c == '/' || isValidFieldName([]byte{c})
+}
@@ -0,0 +1,199 @@
+package headers
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// structuredTest is a test case in the format of the Structured Field Values
+// test suite (github.com/httpwg/structured-field-tests). Parsing tests have
+// raw set, serialisation tests only expected.
+type structuredTest struct {
+	Name       string          `json:"name"`
+	Raw        []string        `json:"raw"`
+	HeaderType string          `json:"header_type"`
+	Expected   json.RawMessage `json:"expected"`
+	MustFail   bool            `json:"must_fail"`
+	CanFail    bool            `json:"can_fail"`
+	Canonical  []string        `json:"canonical"`
+}
+
+// TestStructuredFields runs the vectors in testdata/structured, parsing the
+// raw field lines and serializing the result back, and then serializing the
+// expected values of serialisation.json.
+func TestStructuredFields(t *testing.T) {
+	files, err := filepath.Glob("testdata/structured/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	cases := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		var tests []structuredTest
+		require.NoError(t, json.Unmarshal(data, &tests), file)
+		for _, tc := range tests {
+			cases++
+			t.Run(filepath.Base(file)+"/"+tc.Name, func(t *testing.T) {
+				if tc.Raw != nil {
+					runParseTest(t, tc)
+				} else {
+					runSerializeTest(t, tc)
+				}
+			})
+		}
+	}
+	assert.Greater(t, cases, 150)
+}
+
+func runParseTest(t *testing.T, tc structuredTest) {
+	h := NewHeaders()
+	for _, line := range tc.Raw {
+		h.Add("Example", line)
+	}
+	var parsed any
+	var err error
+	switch tc.HeaderType {
+	case "item":
+		parsed, err = h.Item("Example")
+	case "list":
+		parsed, err = h.List("Example")
+	case "dictionary":
+		parsed, err = h.Dictionary("Example")
+	}
+	if tc.MustFail {
+		assert.ErrorIs(t, err, ErrInvalidStructuredField)
+		return
+	}
+	if tc.CanFail && err != nil {
+		return
+	}
+	require.NoError(t, err)
+	assert.Equal(t, decodeExpected(t, tc.HeaderType, tc.Expected), parsed)
+
+	canonical := strings.Join(tc.Raw, ", ")
+	if tc.Canonical != nil {
+		canonical = tc.Canonical[0]
+	}
+	serialized, err := format(tc.HeaderType, parsed)
+	require.NoError(t, err)
+	assert.Equal(t, canonical, serialized)
+}
+
+func runSerializeTest(t *testing.T, tc structuredTest) {
+	serialized, err := format(tc.HeaderType, decodeExpected(t, tc.HeaderType, tc.Expected))
+	if tc.MustFail {
+		assert.ErrorIs(t, err, ErrInvalidStructuredField)
+		return
+	}
+	require.NoError(t, err)
+	assert.Equal(t, tc.Canonical[0], serialized)
+}
+
+func format(headerType string, value any) (string, error) {
+	switch v := value.(type) {
+	case Item:
+		return FormatItem(v)
+	case List:
+		return FormatList(v)
+	case Dictionary:
+		return FormatDictionary(v)
+	}
+	panic("unknown header type " + headerType)
+}
+
+// decodeExpected turns the JSON representation the test suite uses into
+// the types ParseItem, ParseList and ParseDictionary return.
+func decodeExpected(t *testing.T, headerType string, raw json.RawMessage) any {
+	t.Helper()
+	d := json.NewDecoder(strings.NewReader(string(raw)))
+	d.UseNumber()
+	var v any
+	require.NoError(t, d.Decode(&v))
+	switch headerType {
+	case "item":
+		return expectedItem(t, v)
+	case "list":
+		list := List{}
+		for _, m := range v.([]any) {
+			list = append(list, expectedMember(t, m))
+		}
+		return list
+	case "dictionary":
+		dict := Dictionary{}
+		for _, m := range v.([]any) {
+			pair := m.([]any)
+			dict = append(dict, DictMember{Key: pair[0].(string), Value: expectedMember(t, pair[1])})
+		}
+		return dict
+	}
+	t.Fatalf("unknown header type %q", headerType)
+	return nil
+}
+
+func expectedMember(t *testing.T, v any) Member {
+	pair := v.([]any)
+	if items, ok := pair[0].([]any); ok {
+		list := InnerList{Items: []Item{}, Params: expectedParams(t, pair[1])}
+		for _, item := range items {
+			list.Items = append(list.Items, expectedItem(t, item))
+		}
+		return list
+	}
+	return expectedItem(t, v)
+}
+
+func expectedItem(t *testing.T, v any) Item {
+	pair := v.([]any)
+	return Item{Value: expectedBareItem(t, pair[0]), Params: expectedParams(t, pair[1])}
+}
+
+func expectedParams(t *testing.T, v any) Params {
+	params := Params{}
+	for _, p := range v.([]any) {
+		pair := p.([]any)
+		params = append(params, Param{Key: pair[0].(string), Value: expectedBareItem(t, pair[1])})
+	}
+	return params
+}
+
+func expectedBareItem(t *testing.T, v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			f, err := v.Float64()
+			require.NoError(t, err)
+			return f
+		}
+		i, err := v.Int64()
+		require.NoError(t, err)
+		return i
+	case string, bool:
+		return v
+	case map[string]any:
+		value := v["value"]
+		switch v["__type"] {
+		case "token":
+			return Token(value.(string))
+		case "binary":
+			b, err := base32.StdEncoding.DecodeString(value.(string))
+			require.NoError(t, err)
+			return b
+		case "date":
+			seconds, err := value.(json.Number).Int64()
+			require.NoError(t, err)
+			return time.Unix(seconds, 0).UTC()
+		case "displaystring":
+			return DisplayString(value.(string))
+		}
+	}
+	t.Fatalf("unknown bare item %v", v)
+	return nil
+}
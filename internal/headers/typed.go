@@ -0,0 +1,299 @@
+package headers
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format HTTP-date values are sent in (RFC
+// 9110 section 5.6.7).
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsoleteTimeFormats are the other HTTP-date formats recipients still have
+// to accept, rfc850-date and asctime-date.
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ContentLength returns the value of the Content-Length field, ok is false if
+// there is none. Repeated fields or a list of lengths are an error even if
+// the lengths agree, the message is not worth the ambiguity.
+func (h *Headers) ContentLength() (length int, ok bool, err error) {
+	values := h.Values("Content-Length")
+	if len(values) == 0 {
+		return 0, false, nil
+	}
+	if len(values) > 1 {
+		return 0, true, fmt.Errorf("%w: %d Content-Length fields", ErrInvalidFieldValue, len(values))
+	}
+	value := values[0]
+	if value == "" || strings.Trim(value, "0123456789") != "" {
+		return 0, true, fmt.Errorf("%w: Content-Length %q", ErrInvalidFieldValue, value)
+	}
+	length, err = strconv.Atoi(value)
+	if err != nil {
+		return 0, true, fmt.Errorf("%w: Content-Length %q", ErrInvalidFieldValue, value)
+	}
+	return length, true, nil
+}
+
+func (h *Headers) SetContentLength(length int) {
+	h.Set("Content-Length", strconv.Itoa(length))
+}
+
+// ContentType returns the media type of the Content-Type field, lower-cased,
+// and its parameters. It returns "" and no error if there is no such field.
+func (h *Headers) ContentType() (mediaType string, params map[string]string, err error) {
+	if !h.Has("Content-Type") {
+		return "", nil, nil
+	}
+	return ParseMediaType(h.Get("Content-Type"))
+}
+
+// ParseMediaType parses a media type such as
+// `multipart/form-data; boundary="a b"` (RFC 9110 section 8.3.1). The type,
+// subtype and parameter names are lower-cased, parameter values are
+// unquoted but otherwise kept as they were sent.
+func ParseMediaType(value string) (mediaType string, params map[string]string, err error) {
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	typ, subtype, found := strings.Cut(mediaType, "/")
+	if !found || !IsToken(typ) || !IsToken(subtype) {
+		return "", nil, fmt.Errorf("%w: media type %q", ErrInvalidFieldValue, mediaType)
+	}
+	params, err = parseParameters(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	return mediaType, params, nil
+}
+
+// FormatMediaType is the inverse of ParseMediaType. Parameters are written in
+// sorted order, quoted if they are not tokens.
+func FormatMediaType(mediaType string, params map[string]string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(mediaType))
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString("; " + strings.ToLower(name) + "=")
+		value := params[name]
+		if IsToken(value) {
+			b.WriteString(value)
+		} else {
+			b.WriteString(Quote(value))
+		}
+	}
+	return b.String()
+}
+
+// Tokens returns the elements of the comma-separated list fieldName holds,
+// across all fields of that name, with empty elements dropped as RFC 9110
+// section 5.6.1 asks of recipients. Commas inside quoted strings don't split
+// elements.
+func (h *Headers) Tokens(fieldName string) []string {
+	var tokens []string
+	for _, value := range h.Values(fieldName) {
+		for _, element := range splitList(value, ',') {
+			if element = strings.TrimSpace(element); element != "" {
+				tokens = append(tokens, element)
+			}
+		}
+	}
+	return tokens
+}
+
+// QualityValue is an element of a list weighted with q parameters, such as
+// Accept or Accept-Encoding.
+type QualityValue struct {
+	// Value is the element without its weight, other parameters included.
+	Value string
+	Q     float64
+}
+
+// QualityValues returns the elements of fieldName ordered by weight, highest
+// first, those of the same weight in the order they were sent. Elements
+// without a q parameter weigh 1, ones with a malformed weight are dropped.
+func (h *Headers) QualityValues(fieldName string) []QualityValue {
+	var values []QualityValue
+	for _, element := range h.Tokens(fieldName) {
+		params := splitList(element, ';')
+		value := QualityValue{Value: strings.TrimSpace(params[0]), Q: 1}
+		kept := []string{value.Value}
+		valid := true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			name, weight, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "q") {
+				kept = append(kept, param)
+				continue
+			}
+			q, ok := parseQValue(strings.TrimSpace(weight))
+			if !ok {
+				valid = false
+				break
+			}
+			value.Q = q
+		}
+		if !valid {
+			continue
+		}
+		value.Value = strings.Join(kept, ";")
+		values = append(values, value)
+	}
+	slices.SortStableFunc(values, func(a, b QualityValue) int {
+		switch {
+		case a.Q > b.Q:
+			return -1
+		case a.Q < b.Q:
+			return 1
+		}
+		return 0
+	})
+	return values
+}
+
+// parseQValue parses a weight, "0" to "1" with at most three decimals.
+func parseQValue(s string) (float64, bool) {
+	if len(s) == 0 || len(s) > 5 || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+	if len(s) > 1 && (s[1] != '.' || strings.Trim(s[2:], "0123456789") != "") {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// Time parses the HTTP-date value of fieldName, in any of the three formats
+// RFC 9110 section 5.6.7 has recipients accept.
+func (h *Headers) Time(fieldName string) (time.Time, error) {
+	value := h.Get(fieldName)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%w: no %s field", ErrInvalidFieldValue, fieldName)
+	}
+	return ParseTime(value)
+}
+
+func (h *Headers) SetTime(fieldName string, t time.Time) {
+	h.Set(fieldName, t.UTC().Format(TimeFormat))
+}
+
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range append([]string{TimeFormat}, obsoleteTimeFormats...) {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: HTTP-date %q", ErrInvalidFieldValue, value)
+}
+
+// parseParameters parses the `; name=value` list following a media type.
+func parseParameters(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, param := range splitList(s, ';') {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		name, value, found := strings.Cut(param, "=")
+		name = strings.ToLower(name)
+		if !found || !IsToken(name) {
+			return nil, fmt.Errorf("%w: parameter %q", ErrInvalidFieldValue, param)
+		}
+		if strings.HasPrefix(value, `"`) {
+			unquoted, ok := unquote(value)
+			if !ok {
+				return nil, fmt.Errorf("%w: parameter %q", ErrInvalidFieldValue, param)
+			}
+			value = unquoted
+		} else if !IsToken(value) {
+			return nil, fmt.Errorf("%w: parameter %q", ErrInvalidFieldValue, param)
+		}
+		if _, dup := params[name]; dup {
+			return nil, fmt.Errorf("%w: duplicate parameter %q", ErrInvalidFieldValue, name)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// splitList splits s at every sep that is not inside a quoted string.
+func splitList(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns the content of the quoted-string s, with its quoted-pairs
+// resolved. ok is false if s is not exactly one quoted-string.
+func unquote(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			i++
+			if i == len(s)-1 {
+				return "", false
+			}
+			b.WriteByte(s[i])
+		case c == '"':
+			return "", false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
+
+// Quote returns s as a quoted-string, with double quotes and backslashes
+// escaped.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// IsToken reports whether s is a token, the syntax of field names and of
+// most parameter names and values.
+func IsToken(s string) bool {
+	return s != "" && isValidFieldName([]byte(s))
+}
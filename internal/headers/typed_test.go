@@ -0,0 +1,103 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentLength(t *testing.T) {
+	h := NewHeaders()
+	_, ok, err := h.ContentLength()
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	h.SetContentLength(42)
+	length, ok, err := h.ContentLength()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 42, length)
+
+	for _, value := range []string{"", "-1", "+5", "0x10", "5, 5", "99999999999999999999999"} {
+		h.Set("Content-Length", value)
+		_, ok, err = h.ContentLength()
+		assert.True(t, ok, value)
+		assert.ErrorIs(t, err, ErrInvalidFieldValue, value)
+	}
+
+	// Test: Repeated fields, even agreeing ones
+	h.Set("Content-Length", "5")
+	h.Add("Content-Length", "5")
+	_, _, err = h.ContentLength()
+	assert.ErrorIs(t, err, ErrInvalidFieldValue)
+}
+
+func TestContentType(t *testing.T) {
+	h := NewHeaders()
+	mediaType, params, err := h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "", mediaType)
+	assert.Nil(t, params)
+
+	h.Set("Content-Type", `Multipart/Form-Data; Boundary="a \"b\"; c" ;charset=UTF-8`)
+	mediaType, params, err = h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	assert.Equal(t, map[string]string{"boundary": `a "b"; c`, "charset": "UTF-8"}, params)
+
+	assert.Equal(t, `multipart/form-data; boundary="a \"b\"; c"; charset=UTF-8`, FormatMediaType(mediaType, params))
+
+	for _, value := range []string{"text", "text/", "text/plain; charset", `text/plain; a="unterminated`, "text/plain; a=1; A=2", "text/plain; a=b c"} {
+		_, _, err = ParseMediaType(value)
+		assert.ErrorIs(t, err, ErrInvalidFieldValue, value)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	h := NewHeaders()
+	h.Add("Connection", "keep-alive, , Upgrade")
+	h.Add("Connection", `x-custom="a, b"`)
+	assert.Equal(t, []string{"keep-alive", "Upgrade", `x-custom="a, b"`}, h.Tokens("connection"))
+	assert.True(t, h.HasToken("Connection", "upgrade"))
+	assert.False(t, h.HasToken("Connection", "b"))
+	assert.Nil(t, h.Tokens("Upgrade"))
+}
+
+func TestQualityValues(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Accept", "text/html;level=1;q=0.5, application/json, text/plain; q=0.8, image/*;q=0, bad;q=2, worse;q=0.1234, text/csv;q=0.8")
+	assert.Equal(t, []QualityValue{
+		{"application/json", 1},
+		{"text/plain", 0.8},
+		{"text/csv", 0.8},
+		{"text/html;level=1", 0.5},
+		{"image/*", 0},
+	}, h.QualityValues("Accept"))
+}
+
+func TestTime(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		h := NewHeaders()
+		h.Set("Date", value)
+		got, err := h.Time("Date")
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(got), value)
+	}
+
+	h := NewHeaders()
+	h.SetTime("Last-Modified", want.In(time.FixedZone("CET", 3600)))
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", h.Get("Last-Modified"))
+
+	_, err := h.Time("Date")
+	assert.ErrorIs(t, err, ErrInvalidFieldValue)
+	h.Set("Date", "yesterday")
+	_, err = h.Time("Date")
+	assert.ErrorIs(t, err, ErrInvalidFieldValue)
+}
@@ -0,0 +1,184 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MAX_DRAIN_SIZE is how much of an unread body Close is willing to read and
+// throw away so that the connection can be reused.
+const MAX_DRAIN_SIZE = 256 * 1024
+
+var ErrBodyClosed = errors.New("read on closed request body")
+var ErrBodyNotDrained = errors.New("unread request body too large to discard")
+var ErrDetached = errors.New("request reader detached from its connection")
+
+// NoBody is the Body of requests that have neither a Content-Length nor a
+// chunked Transfer-Encoding.
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) {
+  return 0, io.EOF
+}
+
+func (noBody) Close() error {
+  return nil
+}
+
+// body streams the body of a request off of the Reader it arrived on,
+// feeding the Reader's parser as it goes.
+type body struct {
+  reader *Reader
+  req *Request
+  pending [][]byte // decoded body bytes not handed out yet, they alias the Reader's buffer
+  done bool
+  closed bool
+  err error
+  digest *digestCheck // nil if the body isn't checked against a digest
+}
+
+func (b *body) Read(p []byte) (int, error) {
+  if b.closed {
+    return 0, ErrBodyClosed
+  }
+  return b.read(p)
+}
+
+// Close discards what is left of the body so the next request on the
+// connection can be read. If more than MAX_DRAIN_SIZE bytes are left it
+// gives up and returns ErrBodyNotDrained, the connection should not be
+// reused after that.
+func (b *body) Close() error {
+  if b.closed {
+    return nil
+  }
+  b.closed = true
+  err := b.discard(MAX_DRAIN_SIZE)
+  if err != nil {
+    b.reader.err = err
+  }
+  return err
+}
+
+// discard reads and throws away the rest of the body, giving up after limit
+// bytes. A negative limit means no limit.
+func (b *body) discard(limit int) error {
+  buf := make([]byte, 512)
+  discarded := 0
+  for {
+    if limit >= 0 && discarded > limit {
+      return ErrBodyNotDrained
+    }
+    n, err := b.read(buf)
+    discarded += n
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+  }
+}
+
+// read reads the body and checks it against its digests once it ends.
+func (b *body) read(p []byte) (int, error) {
+  n, err := b.decode(p)
+  if b.digest == nil {
+    return n, err
+  }
+  b.digest.hash.Write(p[:n])
+  if err == io.EOF {
+    verifyErr := b.digest.verify(b.req.Headers, b.req.Trailers)
+    b.digest = nil
+    if verifyErr != nil {
+      b.err = newParseError(ErrDigestMismatch, verifyErr)
+      return n, b.err
+    }
+  }
+  return n, err
+}
+
+func (b *body) decode(p []byte) (int, error) {
+  if b.err != nil {
+    return 0, b.err
+  }
+  if len(p) == 0 {
+    return 0, nil
+  }
+  rr, parser := b.reader, b.reader.parser
+  for {
+    if len(b.pending) > 0 {
+      n := copy(p, b.pending[0])
+      b.pending[0] = b.pending[0][n:]
+      if len(b.pending[0]) == 0 {
+        b.pending = b.pending[1:]
+      }
+      return n, nil
+    }
+    if b.done {
+      return 0, io.EOF
+    }
+
+    if rr.Buffered() == 0 && !rr.eof && (parser.state == ParseStateBody || parser.state == ParseStateChunkData) {
+      // nothing buffered, read the data straight into p rather than going
+      // through the buffer, the parser only has to account for it
+      n, err := rr.reader.Read(p[:min(len(p), parser.bodyRemaining)])
+      if n > 0 {
+        _, events, _ := parser.Feed(p[:n])
+        for _, event := range events {
+          b.done = b.done || event.Type == EventMessageComplete
+        }
+      }
+      if err == io.EOF {
+        rr.eof = true
+        err = nil
+      }
+      if err != nil {
+        b.err = fmt.Errorf("error reading body: %w", err)
+        return n, b.err
+      }
+      if n > 0 {
+        return n, nil
+      }
+      continue
+    }
+
+    numParsed, events, err := parser.Feed(rr.buf[rr.readPos:rr.writePos])
+    rr.readPos += numParsed
+    if err != nil {
+      b.err = fmt.Errorf("error parsing body: %w", err)
+      return 0, b.err
+    }
+    for _, event := range events {
+      switch event.Type {
+      case EventBodyChunk:
+        b.pending = append(b.pending, event.Data)
+      case EventTrailer:
+        b.req.Trailers.Add(event.Name, event.Value)
+      case EventMessageComplete:
+        b.done = true
+      }
+    }
+    if len(b.pending) > 0 || b.done || numParsed > 0 {
+      continue
+    }
+
+    if rr.eof {
+      b.err = fmt.Errorf("error reading body: %w", io.ErrUnexpectedEOF)
+      return 0, b.err
+    }
+    // b.pending is empty, nothing refers to the buffer anymore
+    err = rr.fill()
+    if err == io.EOF {
+      rr.eof = true
+      continue
+    }
+    if err != nil {
+      b.err = fmt.Errorf("error reading body: %w", err)
+      return 0, b.err
+    }
+  }
+}
@@ -0,0 +1,78 @@
+package request
+
+import (
+	"fmt"
+	"http-from-tcp/internal/headers"
+)
+
+// MAX_CHUNK_LINE_SIZE caps the length of a chunk-size line, extensions
+// included.
+const MAX_CHUNK_LINE_SIZE = 4096
+
+// Config caps how much of a request the parser is willing to accept. A zero
+// limit means no limit.
+type Config struct {
+  // MaxRequestLineBytes caps the request line, not counting its CRLF.
+  MaxRequestLineBytes int
+  // MaxHeaderBytes caps the header section, and separately the trailer
+  // section, CRLFs included.
+  MaxHeaderBytes int
+  // MaxHeaderCount caps the number of header fields, and separately the
+  // number of trailer fields.
+  MaxHeaderCount int
+  // MaxBodyBytes caps the decoded body.
+  MaxBodyBytes int
+  // ObsText decides whether header and trailer values may hold obs-text.
+  // Control characters are always rejected.
+  ObsText headers.ObsTextPolicy
+  // VerifyDigests has the body of a request checked against the digests in
+  // its Content-Digest and Repr-Digest fields, or trailers, as it is read.
+  // Reading it fails with ErrDigestMismatch at the end if they differ.
+  VerifyDigests bool
+}
+
+func DefaultConfig() Config {
+  return Config{
+    MaxRequestLineBytes: 8 * 1024,
+    MaxHeaderBytes: 64 * 1024,
+    MaxHeaderCount: 100,
+    VerifyDigests: true,
+  }
+}
+
+// checkLimits is called when parsing needs more data, with the number of
+// bytes fed that could not be parsed yet. It fails if reading more
+// would take the request past one of the configured limits.
+func (p *Parser) checkLimits(buffered int) error {
+  switch p.state {
+  case ParseStateRequestLine:
+    if p.config.MaxRequestLineBytes > 0 && buffered > p.config.MaxRequestLineBytes + len(CRLF) {
+      return newParseError(ErrRequestLineTooLong, nil)
+    }
+  case ParseStateHeaders, ParseStateTrailers:
+    if p.config.MaxHeaderBytes > 0 && p.fieldBytes + buffered > p.config.MaxHeaderBytes {
+      return newParseError(ErrHeadersTooLarge, nil)
+    }
+  case ParseStateChunkSize:
+    if buffered > MAX_CHUNK_LINE_SIZE {
+      return newParseError(ErrInvalidChunk, fmt.Errorf("chunk size line longer than %d bytes", MAX_CHUNK_LINE_SIZE))
+    }
+  }
+  return nil
+}
+
+// countField accounts for n bytes of a header or trailer section having been
+// parsed, done meaning that they were the empty line ending it.
+func (p *Parser) countField(n int, done bool) error {
+  p.fieldBytes += n
+  if !done {
+    p.fieldCount++
+  }
+  if p.config.MaxHeaderBytes > 0 && p.fieldBytes > p.config.MaxHeaderBytes {
+    return newParseError(ErrHeadersTooLarge, nil)
+  }
+  if p.config.MaxHeaderCount > 0 && p.fieldCount > p.config.MaxHeaderCount {
+    return newParseError(ErrHeadersTooLarge, fmt.Errorf("more than %d fields", p.config.MaxHeaderCount))
+  }
+  return nil
+}
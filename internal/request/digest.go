@@ -0,0 +1,71 @@
+package request
+
+import (
+	"http-from-tcp/internal/digest"
+	"http-from-tcp/internal/headers"
+)
+
+// digestCheck hashes a request body to check it against the digests the
+// client sent along (RFC 9530).
+type digestCheck struct {
+	hash *digest.Hash
+	// fields are the fields holding digests of the body as it is sent
+	fields []string
+}
+
+// newDigestCheck returns the check for a body sent with headers h, or nil if
+// there is nothing to check it against. Digests in trailers are only
+// checked if the headers announced them.
+func newDigestCheck(h *headers.Headers) *digestCheck {
+	// without a content coding or a range, the content is the whole
+	// representation and Repr-Digest covers the body too
+	fields := []string{"Content-Digest"}
+	if !h.Has("Content-Encoding") && !h.Has("Content-Range") {
+		fields = append(fields, "Repr-Digest")
+	}
+	check := &digestCheck{}
+	needed := map[digest.Algorithm]bool{}
+	for _, field := range fields {
+		declared := false
+		for _, name := range h.Tokens("Trailer") {
+			declared = declared || headers.CanonicalName(name) == field
+		}
+		if declared {
+			// the algorithms aren't known until the trailer arrives
+			for _, a := range digest.Algorithms {
+				needed[a] = true
+			}
+		} else if !h.Has(field) {
+			continue
+		}
+		check.fields = append(check.fields, field)
+		digests, _ := digest.Parse(h.Get(field))
+		for a := range digests {
+			needed[a] = true
+		}
+	}
+	var algorithms []digest.Algorithm
+	for _, a := range digest.Algorithms {
+		if needed[a] {
+			algorithms = append(algorithms, a)
+		}
+	}
+	if len(algorithms) == 0 {
+		return nil
+	}
+	check.hash, _ = digest.New(algorithms...)
+	return check
+}
+
+// verify checks the body hashed so far against the digests in the headers
+// and trailers.
+func (c *digestCheck) verify(h, trailers *headers.Headers) error {
+	for _, field := range c.fields {
+		for _, value := range append(h.Values(field), trailers.Values(field)...) {
+			if err := c.hash.Verify(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
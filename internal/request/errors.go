@@ -0,0 +1,73 @@
+package request
+
+import (
+	"errors"
+)
+
+// The kinds of ParseError. Match them with errors.Is.
+var (
+  ErrMalformedRequestLine = errors.New("malformed request line")
+  ErrInvalidMethod = errors.New("invalid method")
+  ErrMethodNotImplemented = errors.New("method not implemented")
+  ErrInvalidRequestTarget = errors.New("invalid request target")
+  ErrInvalidVersion = errors.New("invalid HTTP version")
+  ErrVersionNotSupported = errors.New("HTTP version not supported")
+  ErrInvalidHeader = errors.New("invalid header field")
+  ErrInvalidContentLength = errors.New("invalid content-length")
+  ErrInvalidTransferEncoding = errors.New("invalid transfer-encoding")
+  ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+  ErrAmbiguousBodyLength = errors.New("ambiguous body length")
+  ErrInvalidChunk = errors.New("invalid chunk")
+  ErrRequestLineTooLong = errors.New("request line too long")
+  ErrHeadersTooLarge = errors.New("request header fields too large")
+  ErrBodyTooLarge = errors.New("request body too large")
+  ErrDigestMismatch = errors.New("request body does not match its digest")
+)
+
+var statusCodes = map[error]int{
+  ErrMalformedRequestLine: 400,
+  ErrInvalidMethod: 400,
+  ErrMethodNotImplemented: 501,
+  ErrInvalidRequestTarget: 400,
+  ErrInvalidVersion: 400,
+  ErrVersionNotSupported: 505,
+  ErrInvalidHeader: 400,
+  ErrInvalidContentLength: 400,
+  ErrInvalidTransferEncoding: 400,
+  ErrUnsupportedTransferCoding: 501,
+  ErrAmbiguousBodyLength: 400,
+  ErrInvalidChunk: 400,
+  ErrRequestLineTooLong: 414,
+  ErrHeadersTooLarge: 431,
+  ErrBodyTooLarge: 413,
+  ErrDigestMismatch: 400,
+}
+
+// ParseError is returned when a request can not be parsed because of
+// something the client sent.
+type ParseError struct {
+  // Kind is one of the Err values above.
+  Kind error
+  // StatusCode is the status the client should be answered with.
+  StatusCode int
+  // Err says what exactly was wrong, it may be nil.
+  Err error
+}
+
+func newParseError(kind error, err error) *ParseError {
+  return &ParseError{Kind: kind, StatusCode: statusCodes[kind], Err: err}
+}
+
+func (e *ParseError) Error() string {
+  if e.Err == nil {
+    return e.Kind.Error()
+  }
+  return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() []error {
+  if e.Err == nil {
+    return []error{e.Kind}
+  }
+  return []error{e.Kind, e.Err}
+}
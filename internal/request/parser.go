@@ -0,0 +1,291 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"http-from-tcp/internal/headers"
+	"strconv"
+	"strings"
+)
+
+type EventType int
+
+const (
+  // EventRequestLine is reported once the request line is parsed, it is in
+  // Event.RequestLine.
+  EventRequestLine EventType = iota
+  // EventHeader is reported for each header field, in Event.Name and
+  // Event.Value.
+  EventHeader
+  // EventHeadersComplete is reported at the end of the header section.
+  EventHeadersComplete
+  // EventBodyChunk is reported for decoded body bytes, in Event.Data.
+  EventBodyChunk
+  // EventTrailer is reported for each trailer field of a chunked body, in
+  // Event.Name and Event.Value.
+  EventTrailer
+  // EventMessageComplete is reported once the request is complete.
+  EventMessageComplete
+)
+
+type Event struct {
+  Type EventType
+  RequestLine *RequestLine
+  Name string
+  Value string
+  // Data aliases the slice passed to Feed, it is only valid until the
+  // caller reuses that memory.
+  Data []byte
+}
+
+// Parser is the request parsing state machine. It is fed bytes as they
+// arrive from wherever they come from and reports what it found in them as
+// events, it never reads anything itself. One Parser parses any number of
+// requests sent one after the other.
+type Parser struct {
+  state ParseState
+  config Config
+  err error
+  headers *headers.Headers // header fields of the current request so far
+  httpVersion string // of the current request
+  fieldBytes int // size of the header or trailer section so far
+  fieldCount int // fields in the header or trailer section so far
+  bodyRemaining int // bytes left in the body or current chunk
+  bodySize int // decoded size of a chunked body so far
+}
+
+func NewParser(config Config) *Parser {
+  p := &Parser{config: config}
+  p.reset()
+  return p
+}
+
+func (p *Parser) reset() {
+  *p = Parser{state: ParseStateRequestLine, config: p.config, headers: headers.NewHeaders()}
+}
+
+// Feed parses as much of data as it can, returning how many bytes of it were
+// consumed and the events they produced. The bytes of a line that is not
+// complete yet are left unconsumed, they have to be fed again along with
+// what follows them.
+//
+// Feed returns early after EventHeadersComplete and after
+// EventMessageComplete, leaving the rest of data for the next call, so that
+// the caller can decide what to do with the body or the next request. Once
+// Feed has failed it keeps returning the same error.
+func (p *Parser) Feed(data []byte) (int, []Event, error) {
+  if p.err != nil {
+    return 0, nil, p.err
+  }
+  if p.state == ParseStateDone { // start of the next request
+    p.reset()
+  }
+  n, events, err := p.feed(data)
+  if err != nil {
+    p.state = ParseStateError
+    p.err = err
+  }
+  return n, events, err
+}
+
+func (p *Parser) feed(data []byte) (int, []Event, error) {
+  totalParsed := 0
+  var events []Event
+  for {
+    // fmt.Printf("data: '%s'\n", string(data));
+    switch p.state {
+    case ParseStateRequestLine:
+      requestLine, n, err := parseRequestLine(data)
+      if err != nil {
+        return totalParsed, events, fmt.Errorf("error parsing request line: %w", err)
+      }
+      if n == 0 { // need more data
+        return totalParsed, events, p.checkLimits(len(data))
+      }
+      if p.config.MaxRequestLineBytes > 0 && n - len(CRLF) > p.config.MaxRequestLineBytes {
+        return totalParsed, events, newParseError(ErrRequestLineTooLong, nil)
+      }
+      p.httpVersion = requestLine.HttpVersion
+      events = append(events, Event{Type: EventRequestLine, RequestLine: requestLine})
+      p.state = ParseStateHeaders
+      totalParsed += n
+      data = data[n:]
+    case ParseStateHeaders, ParseStateTrailers:
+      // obs-fold, or whitespace ahead of the first field. An intermediary
+      // could unfold the line into the field before it, or drop it, and see
+      // a different message than we would (RFC 9112 sections 2.2 and 5.2).
+      if len(data) > 0 && (data[0] == ' ' || data[0] == '\t') {
+        return totalParsed, events, newParseError(ErrInvalidHeader, fmt.Errorf("field line starts with whitespace"))
+      }
+      name, value, n, done, err := headers.ParseFieldLine(data)
+      if err != nil {
+        return totalParsed, events, newParseError(ErrInvalidHeader, err)
+      }
+      if n == 0 { // need more data
+        return totalParsed, events, p.checkLimits(len(data))
+      }
+      if err := headers.ValidateValue(value, p.config.ObsText); err != nil {
+        return totalParsed, events, newParseError(ErrInvalidHeader, err)
+      }
+      if err := p.countField(n, done); err != nil {
+        return totalParsed, events, err
+      }
+      if !done && p.state == ParseStateHeaders {
+        // parsed again so that the line is kept exactly as it was sent
+        p.headers.Parse(data[:n])
+      }
+      totalParsed += n
+      data = data[n:]
+      if !done {
+        if p.state == ParseStateHeaders {
+          events = append(events, Event{Type: EventHeader, Name: name, Value: value})
+        } else {
+          events = append(events, Event{Type: EventTrailer, Name: name, Value: value})
+        }
+        continue
+      }
+      if p.state == ParseStateTrailers {
+        p.state = ParseStateDone
+        events = append(events, Event{Type: EventMessageComplete})
+        return totalParsed, events, nil
+      }
+
+      events = append(events, Event{Type: EventHeadersComplete})
+      p.fieldBytes = 0
+      p.fieldCount = 0
+      chunked, length, err := p.bodyFraming()
+      if err != nil {
+        return totalParsed, events, err
+      }
+      if chunked {
+        p.state = ParseStateChunkSize
+        return totalParsed, events, nil
+      }
+      if p.config.MaxBodyBytes > 0 && length > p.config.MaxBodyBytes {
+        return totalParsed, events, newParseError(ErrBodyTooLarge, nil)
+      }
+      if length == 0 {
+        p.state = ParseStateDone
+        events = append(events, Event{Type: EventMessageComplete})
+        return totalParsed, events, nil
+      }
+      p.state = ParseStateBody
+      p.bodyRemaining = length
+      return totalParsed, events, nil
+    case ParseStateBody, ParseStateChunkData:
+      n := min(p.bodyRemaining, len(data))
+      if n == 0 { // need more data
+        return totalParsed, events, nil
+      }
+      events = append(events, Event{Type: EventBodyChunk, Data: data[:n]})
+      p.bodyRemaining -= n
+      totalParsed += n
+      data = data[n:]
+      if p.bodyRemaining > 0 {
+        continue
+      }
+      if p.state == ParseStateChunkData {
+        p.state = ParseStateChunkDataEnd
+        continue
+      }
+      p.state = ParseStateDone
+      events = append(events, Event{Type: EventMessageComplete})
+      return totalParsed, events, nil
+    case ParseStateChunkSize:
+      size, n, err := parseChunkSize(data)
+      if err != nil {
+        return totalParsed, events, newParseError(ErrInvalidChunk, err)
+      }
+      if n == 0 { // need more data
+        return totalParsed, events, p.checkLimits(len(data))
+      }
+      p.bodySize += size
+      if p.config.MaxBodyBytes > 0 && p.bodySize > p.config.MaxBodyBytes {
+        return totalParsed, events, newParseError(ErrBodyTooLarge, nil)
+      }
+      totalParsed += n
+      data = data[n:]
+      if size == 0 { // last chunk, only the trailers are left
+        p.state = ParseStateTrailers
+      } else {
+        p.bodyRemaining = size
+        p.state = ParseStateChunkData
+      }
+    case ParseStateChunkDataEnd:
+      if len(data) < len(CRLF) { // need more data
+        return totalParsed, events, nil
+      }
+      if !bytes.HasPrefix(data, []byte(CRLF)) {
+        return totalParsed, events, newParseError(ErrInvalidChunk, fmt.Errorf("chunk data is not followed by CRLF"))
+      }
+      totalParsed += len(CRLF)
+      data = data[len(CRLF):]
+      p.state = ParseStateChunkSize
+    default:
+      return totalParsed, events, nil
+    }
+  }
+}
+
+// bodyFraming works out how the body of the request is delimited (RFC 9112
+// section 6.3), returning either that it is chunked or its length. Anything
+// ambiguous is rejected rather than guessed at, a proxy in front of us
+// guessing differently is how requests get smuggled.
+func (p *Parser) bodyFraming() (bool, int, error) {
+  if p.headers.Has("Transfer-Encoding") {
+    if p.headers.Has("Content-Length") {
+      return false, 0, newParseError(ErrAmbiguousBodyLength, fmt.Errorf("both Transfer-Encoding and Content-Length are set"))
+    }
+    if p.httpVersion == "1.0" {
+      return false, 0, newParseError(ErrAmbiguousBodyLength, fmt.Errorf("Transfer-Encoding in an HTTP/1.0 request"))
+    }
+    codings := strings.Split(p.headers.Get("Transfer-Encoding"), ",")
+    for i, coding := range codings {
+      coding = strings.TrimSpace(coding)
+      if coding == "" {
+        return false, 0, newParseError(ErrInvalidTransferEncoding, fmt.Errorf("empty transfer coding"))
+      }
+      if strings.EqualFold(coding, "chunked") != (i == len(codings) - 1) {
+        return false, 0, newParseError(ErrInvalidTransferEncoding, fmt.Errorf("chunked is not the final transfer coding"))
+      }
+    }
+    if len(codings) > 1 { // chunked is all we know how to decode
+      return false, 0, newParseError(ErrUnsupportedTransferCoding, fmt.Errorf("%s", p.headers.Get("Transfer-Encoding")))
+    }
+    return true, 0, nil
+  }
+
+  // repeated Content-Length fields are rejected whether or not their values
+  // agree
+  length, _, err := p.headers.ContentLength()
+  if err != nil {
+    return false, 0, newParseError(ErrInvalidContentLength, err)
+  }
+  return false, length, nil
+}
+
+// parseChunkSize parses a chunk-size line, chunk-size [ chunk-ext ] CRLF,
+// returning the size of the chunk that follows. Chunk extensions are
+// ignored.
+func parseChunkSize(data []byte) (int, int, error) {
+  ind := bytes.Index(data, []byte(CRLF))
+  if ind == -1 {
+    return 0, 0, nil // need more data, haven't found CRLF
+  }
+
+  line := data[:ind]
+  if bytes.ContainsAny(line, "\r\n") {
+    return 0, 0, fmt.Errorf("bare CR or LF in chunk size line")
+  }
+  if extInd := bytes.IndexByte(line, ';'); extInd != -1 {
+    line = bytes.TrimRight(line[:extInd], " \t")
+  }
+  if len(line) == 0 {
+    return 0, 0, fmt.Errorf("missing chunk size")
+  }
+  size, err := strconv.ParseUint(string(line), 16, 31)
+  if err != nil {
+    return 0, 0, fmt.Errorf("malformed chunk size: %s", line)
+  }
+  return int(size), ind + len(CRLF), nil
+}
@@ -0,0 +1,116 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feedAll feeds data to the parser numBytesPerFeed bytes at a time, the way
+// it would arrive from a socket, re-feeding whatever was left unconsumed.
+func feedAll(t *testing.T, p *Parser, data string, numBytesPerFeed int) []Event {
+	t.Helper()
+	var events []Event
+	pending := []byte{}
+	for pos := 0; pos < len(data); {
+		end := min(pos+numBytesPerFeed, len(data))
+		pending = append(pending, data[pos:end]...)
+		pos = end
+		for len(pending) > 0 {
+			n, evs, err := p.Feed(pending)
+			require.NoError(t, err)
+			for _, ev := range evs {
+				// copy body data, it aliases pending
+				ev.Data = append([]byte(nil), ev.Data...)
+				events = append(events, ev)
+			}
+			pending = pending[n:]
+			if n == 0 && len(evs) == 0 {
+				break
+			}
+		}
+	}
+	assert.Empty(t, pending)
+	return events
+}
+
+func TestParser(t *testing.T) {
+	data := "POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n" +
+		"0\r\n" +
+		"X-Checksum: abc123\r\n" +
+		"\r\n" +
+		"GET /next HTTP/1.1\r\n" +
+		"\r\n"
+
+	for _, numBytesPerFeed := range []int{1, 3, 7, len(data)} {
+		p := NewParser(DefaultConfig())
+		events := feedAll(t, p, data, numBytesPerFeed)
+
+		types := []EventType{}
+		body := ""
+		for _, ev := range events {
+			types = append(types, ev.Type)
+			if ev.Type == EventBodyChunk {
+				body += string(ev.Data)
+			}
+		}
+		assert.Equal(t, []EventType{
+			EventRequestLine, EventHeader, EventHeader, EventHeadersComplete,
+			EventBodyChunk, EventTrailer, EventMessageComplete,
+			EventRequestLine, EventHeadersComplete, EventMessageComplete,
+		}, compact(types), "%d bytes per feed", numBytesPerFeed)
+		assert.Equal(t, "hello", body)
+		assert.Equal(t, "POST", events[0].RequestLine.Method)
+		assert.Equal(t, "Host", events[1].Name)
+		assert.Equal(t, "localhost:42069", events[1].Value)
+	}
+}
+
+// compact merges runs of body chunk events, how many there are depends on
+// how the data was split up.
+func compact(types []EventType) []EventType {
+	out := []EventType{}
+	for _, typ := range types {
+		if typ == EventBodyChunk && len(out) > 0 && out[len(out)-1] == EventBodyChunk {
+			continue
+		}
+		out = append(out, typ)
+	}
+	return out
+}
+
+func TestParserFeed(t *testing.T) {
+	// Test: Incomplete lines are left unconsumed
+	p := NewParser(DefaultConfig())
+	n, events, err := p.Feed([]byte("GET / HTTP/1.1\r\nHost: loc"))
+	require.NoError(t, err)
+	assert.Equal(t, len("GET / HTTP/1.1\r\n"), n)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventRequestLine, events[0].Type)
+
+	// Test: Feed stops after the headers and after the message
+	p = NewParser(DefaultConfig())
+	data := []byte("POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcGET / HTTP/1.1\r\n\r\n")
+	n, events, err = p.Feed(data)
+	require.NoError(t, err)
+	assert.Equal(t, EventHeadersComplete, events[len(events)-1].Type)
+	data = data[n:]
+	n, events, err = p.Feed(data)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "abc", string(events[0].Data))
+	assert.Equal(t, EventMessageComplete, events[1].Type)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(data[n:]))
+
+	// Test: Errors stick
+	p = NewParser(DefaultConfig())
+	_, _, err = p.Feed([]byte("GET / HTTP/9.9\r\n\r\n"))
+	require.ErrorIs(t, err, ErrVersionNotSupported)
+	_, _, err = p.Feed([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.ErrorIs(t, err, ErrVersionNotSupported)
+}
@@ -3,9 +3,10 @@ package request
 import (
 	"bytes"
 	"fmt"
+	"http-from-tcp/internal/cookie"
 	"http-from-tcp/internal/headers"
 	"io"
-	"strconv"
+	"net/url"
 	"unicode"
 )
 
@@ -15,123 +16,211 @@ const (
 	ParseStateRequestLine ParseState = iota
 	ParseStateHeaders
   ParseStateBody
+  ParseStateChunkSize
+  ParseStateChunkData
+  ParseStateChunkDataEnd
+  ParseStateTrailers
 	ParseStateDone
 	ParseStateError
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers headers.Headers
-	Body []byte
-	state ParseState
+	Headers *headers.Headers
+	// Body streams the request body off of the connection. It is NoBody if
+	// the request has neither a Content-Length nor a chunked
+	// Transfer-Encoding.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields sent after a chunked body. They are
+	// only filled in once Body has been read to the end.
+	Trailers *headers.Headers
+}
+
+// KeepAlive reports whether the client expects the connection to stay open
+// after this request. HTTP/1.1 connections are persistent unless the client
+// sends Connection: close, HTTP/1.0 ones only if it asks for keep-alive.
+func (r *Request) KeepAlive() bool {
+  if r.RequestLine.HttpVersion == "1.0" {
+    return r.Headers.HasToken("Connection", "keep-alive")
+  }
+  return !r.Headers.HasToken("Connection", "close")
+}
+
+// Cookies returns the cookies sent in the request's Cookie fields, in order.
+func (r *Request) Cookies() []*cookie.Cookie {
+  var cookies []*cookie.Cookie
+  for _, value := range r.Headers.Values("Cookie") {
+    cookies = append(cookies, cookie.Parse(value)...)
+  }
+  return cookies
+}
+
+// Cookie returns the first cookie called name, or cookie.ErrNoCookie if the
+// request has none.
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+  for _, c := range r.Cookies() {
+    if c.Name == name {
+      return c, nil
+    }
+  }
+  return nil, cookie.ErrNoCookie
 }
 
 type RequestLine struct {
 	HttpVersion   string
+	// RequestTarget is the request target exactly as it was sent.
 	RequestTarget string
 	Method        string
+	// URL is the parsed RequestTarget. Which parts of it are set depends on
+	// TargetForm, origin-form targets only have a path and query.
+	URL        *url.URL
+	TargetForm TargetForm
 }
 
 const CRLF = "\r\n"
 const BUFFER_SIZE = 8
-const MAX_BUF_SIZE = 1024
+
+// Reader parses successive requests off of a single connection. Bytes read
+// past the end of one request stay buffered and are used for the next one.
+type Reader struct {
+  reader io.Reader
+  buf []byte
+  readPos int // start of unparsed data
+  writePos int // end of unparsed data
+  eof bool
+  parser *Parser
+  body *body // body of the last request returned
+  err error // set once the stream can no longer be parsed
+}
+
+func NewReader(reader io.Reader) *Reader {
+  return NewReaderWithConfig(reader, DefaultConfig())
+}
+
+func NewReaderWithConfig(reader io.Reader, config Config) *Reader {
+  return &Reader{reader: reader, buf: make([]byte, BUFFER_SIZE), parser: NewParser(config)}
+}
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	r := Request{state: ParseStateRequestLine}
-	r.Headers = headers.NewHeaders()
-	buf := make([]byte, BUFFER_SIZE)
-  readPos := 0
-  writePos := 0
-	for r.state != ParseStateDone {
-    if writePos == len(buf) { // we have reached end of buffer
-      if readPos > 0 { // we should discard the data before readPos, as it has been both read and processed
-        copy(buf, buf[readPos:writePos])
-        writePos -= readPos
-        readPos = 0
-      } else { // readPos at 0, meaning the entire buffer is full of unparsed data, we must grow it
-        newBuf := make([]byte, len(buf) * 2)
-        copy(newBuf, buf)
-        buf = newBuf
-      }
+  return NewReader(reader).ReadRequest()
+}
+
+// Buffered returns the number of bytes that have been read from the
+// underlying reader but not yet parsed.
+func (rr *Reader) Buffered() int {
+  return rr.writePos - rr.readPos
+}
+
+// Detach hands over the bytes that have been read from the underlying reader
+// but not parsed yet, to a caller taking the connection over. The body of
+// the last request should have been read to the end, what is left of it
+// would be mixed up in them. The Reader and that body can't be used after
+// that.
+func (rr *Reader) Detach() []byte {
+  buffered := bytes.Clone(rr.buf[rr.readPos:rr.writePos])
+  rr.err = ErrDetached
+  if rr.body != nil {
+    rr.body.pending = nil
+    rr.body.err = ErrDetached
+    rr.body = nil
+  }
+  return buffered
+}
+
+// ReadRequest parses the next request up to the end of its headers, leaving
+// the body to be streamed through Request.Body. Whatever the caller left
+// unread of the previous request's body is discarded first. It returns
+// io.EOF if the underlying reader is exhausted before any byte of a new
+// request has been read.
+func (rr *Reader) ReadRequest() (*Request, error) {
+  if rr.body != nil {
+    err := rr.body.discard(-1)
+    rr.body = nil
+    if err != nil {
+      rr.err = err
     }
+  }
+  if rr.err != nil {
+    return nil, rr.err
+  }
 
-		numRead, err := reader.Read(buf[writePos:]) // extend the middle portion (read but not processed)
-		if err == io.EOF {
-			r.state = ParseStateDone
-			break;
-		}
-    writePos += numRead
-		if err != nil {
-			return nil, fmt.Errorf("error reading from reader: %w", err)
-		}
-		numParsed, err := r.parse(buf[readPos:writePos])
+	r := Request{}
+	r.Trailers = headers.NewHeaders()
+	for {
+    // parse whatever is already buffered first, it may hold a full request
+		numParsed, events, err := rr.parser.Feed(rr.buf[rr.readPos:rr.writePos])
 		if err != nil {
 			return nil, fmt.Errorf("error parsing buffer: %w", err)
 		}
-    // fmt.Printf("total Parsed: %d\n", numParsed)
-    // fmt.Printf("state: %d\n\n", r.state)
-    readPos += numParsed
-	}
-	return &r, nil
-}
-
-func (r *Request) parse(data []byte) (int, error) {
-  totalParsed := 0
-  for {
-    // fmt.Printf("data: '%s'\n", string(data));
-    switch r.state {
-    case ParseStateRequestLine:
-      // fmt.Printf("data: '%s'\n", string(data));
-      requestLine, n, err := parseRequestLine(data)
-      if err != nil {
-        return 0, fmt.Errorf("error parsing request line: %w", err)
+    rr.readPos += numParsed
+    headersDone, complete := false, false
+    for _, event := range events {
+      switch event.Type {
+      case EventRequestLine:
+        r.RequestLine = *event.RequestLine
+      case EventHeadersComplete:
+        headersDone = true
+      case EventMessageComplete:
+        complete = true
       }
-      if n == 0 { // need more data
-        return totalParsed, nil
-      }
-      r.state = ParseStateHeaders
-      r.RequestLine = *requestLine
-      data = data[n:]
-      totalParsed += n
-      // fmt.Printf("parsed line: '%s'\n", r.RequestLine)
-    case ParseStateHeaders:
-      // fmt.Printf("data: '%s'\n", string(data));
-      n, done, err := r.Headers.Parse(data)
-      if err != nil {
-        return 0, fmt.Errorf("error parsing headers, %w", err)
-      }
-      if n == 0 { // need more data
-        return totalParsed, nil
-      }
-      totalParsed += n
-      data = data[n:]
-      if done {
-        r.state = ParseStateBody
-      }
-    case ParseStateBody:
-      // fmt.Printf("data: '%s'\n", string(data));
-      contentLength := r.Headers.Get("Content-Length")
-      // fmt.Printf("content length: %s\n", contentLength)
-      if contentLength == "" {
-        r.state = ParseStateDone
-        return totalParsed, nil
-      }
-      length, err := strconv.Atoi(contentLength)
-      if err != nil {
-        return 0, fmt.Errorf("error parsing content-length: %w", err)
-      }
-      remaining := min(length - len(r.Body), len(data))
-      r.Body = append(r.Body, data[:remaining]...)
-      if len(r.Body) > length {
-        return 0, fmt.Errorf("body does not match content-length: %d > %d, '%s'", len(data), length, string(data))
+    }
+    if headersDone {
+      r.Headers = rr.parser.headers
+      if complete {
+        r.Body = NoBody
+      } else {
+        rr.body = &body{reader: rr, req: &r}
+        if rr.parser.config.VerifyDigests {
+          rr.body.digest = newDigestCheck(r.Headers)
+        }
+        r.Body = rr.body
       }
-      if len(r.Body) == length {
-        r.state = ParseStateDone
+      return &r, nil
+    }
+
+    if rr.eof {
+      if rr.parser.state == ParseStateRequestLine {
+        if rr.Buffered() == 0 {
+          return nil, io.EOF
+        }
+        rr.err = fmt.Errorf("error reading request line: %w", io.ErrUnexpectedEOF)
+        return nil, rr.err
       }
-      totalParsed += remaining
-      return totalParsed, nil
+      // the connection ended inside of the headers, a request that was cut
+      // short is not one to hand to a handler
+      rr.err = fmt.Errorf("error reading headers: %w", io.ErrUnexpectedEOF)
+      return nil, rr.err
+    }
+
+    err = rr.fill()
+    if err == io.EOF {
+      rr.eof = true
+      continue
+    }
+		if err != nil {
+			return nil, fmt.Errorf("error reading from reader: %w", err)
+		}
+	}
+}
+
+// fill reads more data from the underlying reader into the buffer, making
+// room for it first if needed.
+func (rr *Reader) fill() error {
+  if rr.writePos == len(rr.buf) { // we have reached end of buffer
+    if rr.readPos > 0 { // we should discard the data before readPos, as it has been both read and processed
+      copy(rr.buf, rr.buf[rr.readPos:rr.writePos])
+      rr.writePos -= rr.readPos
+      rr.readPos = 0
+    } else { // readPos at 0, meaning the entire buffer is full of unparsed data, we must grow it
+      newBuf := make([]byte, len(rr.buf) * 2)
+      copy(newBuf, rr.buf)
+      rr.buf = newBuf
     }
   }
+
+  numRead, err := rr.reader.Read(rr.buf[rr.writePos:]) // extend the middle portion (read but not processed)
+  rr.writePos += numRead
+  return err
 }
 
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
@@ -144,29 +233,42 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 
 	line := data[:ind]
   // fmt.Printf("line: '%s'\n", string(line))
+  if bytes.ContainsAny(line, "\r\n") {
+    return nil, 0, newParseError(ErrMalformedRequestLine, fmt.Errorf("bare CR or LF in request line"))
+  }
   parts := bytes.Split(line, []byte(" "))
 	if (len(parts) != 3) {
-		return nil, 0, fmt.Errorf("malformed request line: %s", line)
+		return nil, 0, newParseError(ErrMalformedRequestLine, fmt.Errorf("%q", line))
 	}
 
 	method := parts[0]
   // fmt.Printf("method: %s\n", string(method))
 	if !isAllCapitalAlphabetCharacters(method) {
-		return nil, 0, fmt.Errorf("malformed method: %s", method)
+		return nil, 0, newParseError(ErrInvalidMethod, fmt.Errorf("%q", method))
+	}
+	if !isKnownMethod(string(method)) {
+		return nil, 0, newParseError(ErrMethodNotImplemented, fmt.Errorf("%s", method))
 	}
 
 	requestTarget := parts[1]
   // fmt.Printf("requestTarget: %s\n", string(requestTarget))
-	if !bytes.HasPrefix(requestTarget, []byte("/")) {
-		return nil, 0, fmt.Errorf("malformed request target: %s", requestTarget)
-	}
+  u, form, err := parseRequestTarget(string(method), string(requestTarget))
+  if err != nil {
+    return nil, 0, newParseError(ErrInvalidRequestTarget, err)
+  }
 
 	version, err := parseRequestLineVersion(parts[2])
 	if err != nil {
-		return nil, 0, fmt.Errorf("malformed version: %s, %w", version, err)
+		return nil, 0, err
 	}
 
-	requestLine := RequestLine{HttpVersion: string(version), RequestTarget: string(requestTarget), Method: string(method)}
+	requestLine := RequestLine{
+		HttpVersion: string(version),
+		RequestTarget: string(requestTarget),
+		Method: string(method),
+		URL: u,
+		TargetForm: form,
+	}
 
 	return &requestLine, len(line) + len(CRLF), nil
 }
@@ -174,21 +276,41 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 func parseRequestLineVersion(s []byte) ([]byte, error) {
   // fmt.Printf("line version: %s\n", s)
 	if !bytes.HasPrefix(s, []byte("HTTP/")) {
-		return nil, fmt.Errorf("version does not contain http prefix")
+		return nil, newParseError(ErrInvalidVersion, fmt.Errorf("version does not contain http prefix"))
 	}
 	parts := bytes.Split(s, []byte("/"))
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("version has too many parts split by '/'")
+		return nil, newParseError(ErrInvalidVersion, fmt.Errorf("version has too many parts split by '/'"))
 	}
   // fmt.Printf("parts[0]: '%s'\n", parts[0])
   // fmt.Printf("parts[1]: '%s'\n", parts[1])
-	if !bytes.Equal(parts[1], []byte("1.1")) {
-		return nil, fmt.Errorf("HTTP version is not 1.1")
+  version := parts[1]
+  if len(version) != 3 || !isDigit(version[0]) || version[1] != '.' || !isDigit(version[2]) {
+    return nil, newParseError(ErrInvalidVersion, fmt.Errorf("version is not of the form DIGIT.DIGIT"))
+  }
+	if version[0] != '1' {
+		return nil, newParseError(ErrVersionNotSupported, fmt.Errorf("HTTP/%s", version))
+	}
+	// a higher minor version is understood to be compatible, and answered
+	// as 1.1 (RFC 9110 section 6.2)
+	if version[2] > '1' {
+		return []byte("1.1"), nil
 	}
-	version := bytes.Split(s, []byte("HTTP/"))[1]
 	return version, nil
 }
 
+func isDigit(b byte) bool {
+  return b >= '0' && b <= '9'
+}
+
+func isKnownMethod(method string) bool {
+  switch method {
+  case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+    return true
+  }
+  return false
+}
+
 func isAllCapitalAlphabetCharacters(s []byte) bool {
 	if len(s) == 0 {
 		return false
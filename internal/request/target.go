@@ -0,0 +1,84 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is the form a request target was sent in (RFC 9112 section 3.2).
+type TargetForm int
+
+const (
+  // TargetFormOrigin is an absolute path with an optional query, "/where?q=now".
+  TargetFormOrigin TargetForm = iota
+  // TargetFormAbsolute is a full URI, "http://www.example.org/where?q=now",
+  // mostly sent to proxies.
+  TargetFormAbsolute
+  // TargetFormAuthority is the host and port of a CONNECT request,
+  // "www.example.com:443".
+  TargetFormAuthority
+  // TargetFormAsterisk is the "*" of a server wide OPTIONS request.
+  TargetFormAsterisk
+)
+
+// parseRequestTarget parses the request target of a request with the given
+// method into a URL. Path holds the percent-decoded path and RawPath the
+// path as it was sent, if the two differ, see url.URL.EscapedPath.
+func parseRequestTarget(method string, target string) (*url.URL, TargetForm, error) {
+  if target == "" {
+    return nil, 0, fmt.Errorf("empty request target")
+  }
+  for i := 0; i < len(target); i++ {
+    if target[i] <= ' ' || target[i] >= 0x7f {
+      return nil, 0, fmt.Errorf("invalid character in request target: %q", target)
+    }
+  }
+  if strings.Contains(target, "#") {
+    return nil, 0, fmt.Errorf("request target contains a fragment: %s", target)
+  }
+
+  if method == "CONNECT" {
+    host, port, err := net.SplitHostPort(target)
+    if err != nil || host == "" || port == "" {
+      return nil, 0, fmt.Errorf("CONNECT request target is not host:port: %s", target)
+    }
+    return &url.URL{Host: target}, TargetFormAuthority, nil
+  }
+
+  if strings.HasPrefix(target, "/") {
+    // not url.ParseRequestURI, it would take "//x/y" for a host and path
+    rawPath, rawQuery, _ := strings.Cut(target, "?")
+    path, err := url.PathUnescape(rawPath)
+    if err != nil {
+      return nil, 0, fmt.Errorf("malformed request target path: %w", err)
+    }
+    u := &url.URL{Path: path, RawQuery: rawQuery}
+    if u.EscapedPath() != rawPath {
+      u.RawPath = rawPath
+    }
+    return u, TargetFormOrigin, nil
+  }
+
+  if target == "*" {
+    if method != "OPTIONS" {
+      return nil, 0, fmt.Errorf("asterisk request target is only allowed for OPTIONS")
+    }
+    return &url.URL{Path: "*"}, TargetFormAsterisk, nil
+  }
+
+  // the query is left as it was sent, it is up to URL.Query to make what
+  // it can of it
+  u, err := url.ParseRequestURI(target)
+  if err != nil {
+    return nil, 0, fmt.Errorf("malformed request target: %w", err)
+  }
+  if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Opaque != "" {
+    return nil, 0, fmt.Errorf("malformed absolute request target: %s", target)
+  }
+  if u.User != nil {
+    return nil, 0, fmt.Errorf("request target contains userinfo: %s", target)
+  }
+  return u, TargetFormAbsolute, nil
+}
@@ -0,0 +1,35 @@
+package response
+
+import (
+  "fmt"
+  "http-from-tcp/internal/digest"
+)
+
+// DigestBody has the body hashed as it is written and its digest sent in a
+// Content-Digest field (RFC 9530), with the algorithms given, or all those
+// the digest package supports if none are. It has to be called before the
+// headers are written.
+//
+// The field goes in the headers if the whole body is known by then, that is
+// for a response written through Write that was buffered to the end.
+// Otherwise it is a trailer, which is dropped like any other if the client
+// doesn't accept trailers or the body isn't chunked. A Content-Digest field
+// set by the handler is left alone.
+func (w *Writer) DigestBody(algorithms ...digest.Algorithm) error {
+  if w.WriterState > WriterStateHeaders || w.buf != nil || w.discarded > 0 {
+    return fmt.Errorf("invalid state, body already written")
+  }
+  d, err := digest.New(algorithms...)
+  if err != nil {
+    return err
+  }
+  w.digest = d
+  return nil
+}
+
+// hashBody feeds p, body the handler has written, to the body's digest.
+func (w *Writer) hashBody(p []byte) {
+  if w.digest != nil {
+    w.digest.Write(p)
+  }
+}
@@ -0,0 +1,179 @@
+package response
+
+import (
+  "fmt"
+  "http-from-tcp/internal/headers"
+)
+
+// MAX_BUFFERED_BODY_SIZE is how much of a body written through Write is held
+// back to be sent with a Content-Length. Bodies that outgrow it are sent
+// chunked.
+const MAX_BUFFERED_BODY_SIZE = 4096
+
+// Header returns the headers of a response written through Write. They can
+// be changed until the first Flush, or until the body outgrows
+// MAX_BUFFERED_BODY_SIZE, after that changes are ignored.
+func (w *Writer) Header() *headers.Headers {
+  if w.header == nil {
+    w.header = headers.NewHeaders()
+  }
+  return w.header
+}
+
+// WriteHeader sets the status code of a response written through Write.
+// Nothing is written yet, the headers are sent along with the body. Without
+// it the status is 200.
+//
+// A 1xx status is sent right away instead, as an interim response carrying
+// what Header holds at the time, such as the Link fields of 103 Early
+// Hints. Header is left as it is for the final response. HTTP/1.0 clients
+// don't understand interim responses and are sent none.
+func (w *Writer) WriteHeader(statusCode StatusCode) error {
+  if w.WriterState != WriterStateStatusLine || w.status != 0 {
+    return fmt.Errorf("invalid state, status already set")
+  }
+  if !statusCode.Valid() {
+    return fmt.Errorf("invalid status code %d, not three digits", statusCode)
+  }
+  if statusCode.Informational() {
+    if w.HttpVersion == "1.0" {
+      return nil
+    }
+    if err := w.WriteStatusLine(statusCode); err != nil {
+      return err
+    }
+    return w.WriteHeaders(w.Header())
+  }
+  w.status = statusCode
+  return nil
+}
+
+// Write writes p as part of the body, making the Writer an io.Writer.
+//
+// If nothing has been written yet the response is framed automatically: the
+// body is buffered so a response that turns out small goes out with a
+// Content-Length once the handler returns, and one that outgrows
+// MAX_BUFFERED_BODY_SIZE is sent chunked from then on, unless Header already
+// has a Content-Length. After an explicit WriteHeaders p is written as is,
+// or as a chunk if the headers asked for a chunked body.
+func (w *Writer) Write(p []byte) (int, error) {
+  switch w.WriterState {
+  case WriterStateStatusLine:
+    if w.status == 0 {
+      w.status = StatusCode200
+    }
+    if !w.sendsBody() {
+      // a HEAD response is held back whatever its size, so it can get the
+      // Content-Length a GET would have had
+      n, err := w.discard(p)
+      w.discarded += n
+      w.hashBody(p[:n])
+      return n, err
+    }
+    w.buf = append(w.buf, p...)
+    w.hashBody(p)
+    if len(w.buf) > MAX_BUFFERED_BODY_SIZE {
+      if err := w.commit(false); err != nil {
+        return 0, err
+      }
+    }
+    return len(p), nil
+  case WriterStateBody:
+    if w.chunked {
+      return w.WriteChunkedBody(p)
+    }
+    return w.WriteBody(p)
+  }
+  return 0, fmt.Errorf("invalid state, headers not written")
+}
+
+// Flush sends whatever has been written so far. A response written through
+// Write has its headers sent and its body switched to chunked, unless
+// Header has a Content-Length.
+func (w *Writer) Flush() error {
+  if w.WriterState == WriterStateStatusLine {
+    if w.status == 0 {
+      w.status = StatusCode200
+    }
+    return w.commit(false)
+  }
+  if w.WriterState != WriterStateBody {
+    return fmt.Errorf("invalid state, not in body state")
+  }
+  if f, ok := w.Writer.(interface{ Flush() error }); ok {
+    return f.Flush()
+  }
+  return nil
+}
+
+// Finish completes the response once the handler is done with it, the
+// server calls it after the handler returns. A response still buffered is
+// sent with a Content-Length, a handler that wrote nothing at all sends an
+// empty 200, and a chunked body gets its last chunk. If the response can't
+// be completed KeepAlive is cleared, as the client can't find its end.
+func (w *Writer) Finish() error {
+  switch w.WriterState {
+  case WriterStateStatusLine:
+    if w.status == 0 {
+      w.status = StatusCode200
+    }
+    if err := w.commit(true); err != nil {
+      w.KeepAlive = false
+      return err
+    }
+  case WriterStateHeaders:
+    w.KeepAlive = false
+    return fmt.Errorf("response ended before its headers")
+  }
+  if w.WriterState == WriterStateBody {
+    if w.chunked || w.unchunked {
+      if _, err := w.WriteChunkedBodyDone(nil); err != nil {
+        w.KeepAlive = false
+        return err
+      }
+    } else if w.sendsBody() && w.contentLength >= 0 && w.written < w.contentLength {
+      w.KeepAlive = false
+      w.WriterState = WriterStateDone
+      return fmt.Errorf("body shorter than its Content-Length of %d", w.contentLength)
+    }
+  }
+  w.WriterState = WriterStateDone
+  return nil
+}
+
+// commit writes the status line and headers of a response written through
+// Write, followed by the body buffered so far. If that is the whole body,
+// final, it gets a Content-Length, otherwise it is sent chunked, as it is
+// if trailers have to follow it. Framing set up by the handler is left
+// alone, and none is added for statuses that never have a body.
+func (w *Writer) commit(final bool) error {
+  h := w.Header()
+  if !bodyless(w.status) && !h.Has("Content-Length") && !h.HasToken("Transfer-Encoding", "chunked") {
+    trailers := w.AcceptsTrailers && w.HttpVersion != "1.0" && (len(w.trailerNames) > 0 || h.Has("Trailer"))
+    if final && !trailers {
+      h.SetContentLength(len(w.buf) + w.discarded)
+    } else {
+      h.Add("Transfer-Encoding", "chunked")
+    }
+    // the whole body is known, its digest can go ahead of it
+    if final && w.digest != nil && !h.Has("Content-Digest") {
+      h.Set("Content-Digest", w.digest.Field())
+    }
+  }
+  if err := w.WriteStatusLine(w.status); err != nil {
+    return err
+  }
+  if err := w.WriteHeaders(h); err != nil {
+    return err
+  }
+  // the buffered body has been hashed already
+  buf := w.buf
+  w.buf = nil
+  var err error
+  if w.chunked {
+    _, err = w.writeChunk(buf, nil)
+  } else {
+    _, err = w.writeBody(buf)
+  }
+  return err
+}
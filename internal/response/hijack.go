@@ -0,0 +1,42 @@
+package response
+
+import (
+  "errors"
+  "fmt"
+  "net"
+)
+
+// Hijacker is implemented by the io.Writer of a Writer whose connection can
+// be taken over, see Writer.Hijack.
+type Hijacker interface {
+  Hijack() (net.Conn, []byte, error)
+}
+
+var ErrNotHijackable = errors.New("connection can't be hijacked")
+
+// Hijack takes the connection over from the server, for protocols such as
+// WebSocket or a CONNECT tunnel. It returns the connection along with the
+// bytes the server had read from it but not parsed yet, which come before
+// anything read from the connection. The server doesn't touch the
+// connection after that, closing it is up to the caller, and the Writer
+// can't be used anymore.
+//
+// It fails if the final response has been started, interim responses are
+// fine, or if the server can't hand the connection over, because other
+// requests were pipelined on it for example.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+  if w.WriterState != WriterStateStatusLine || w.buf != nil || w.discarded > 0 {
+    return nil, nil, fmt.Errorf("invalid state, response already started")
+  }
+  h, ok := w.Writer.(Hijacker)
+  if !ok {
+    return nil, nil, ErrNotHijackable
+  }
+  conn, buffered, err := h.Hijack()
+  if err != nil {
+    return nil, nil, err
+  }
+  w.WriterState = WriterStateDone
+  w.KeepAlive = false
+  return conn, buffered, nil
+}
@@ -0,0 +1,106 @@
+package response
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "os"
+)
+
+// COPY_BUFFER_SIZE is how much of the source ReadFrom reads at a time when
+// the body can't go straight to the connection, each read is a chunk of a
+// chunked body.
+const COPY_BUFFER_SIZE = 32 * 1024
+
+// ReadFrom writes the contents of src as the body, making the Writer an
+// io.ReaderFrom, which io.Copy uses.
+//
+// A body that is written as is gets handed to the underlying writer's own
+// ReadFrom, so that copying a file to a TCP connection is left to the
+// kernel, with sendfile or splice on Linux. If nothing has been written
+// through Write yet and src is a regular file, the response gets its
+// remaining size as Content-Length. Chunked bodies, bodies being digested
+// and HEAD responses are copied through Write instead.
+func (w *Writer) ReadFrom(src io.Reader) (int64, error) {
+  if w.WriterState == WriterStateStatusLine && w.buf == nil && w.discarded == 0 {
+    if w.status == 0 {
+      w.status = StatusCode200
+    }
+    h := w.Header()
+    size, ok := fileSize(src)
+    if ok && w.sendsBody() && w.digest == nil && !h.Has("Content-Length") && !h.HasToken("Transfer-Encoding", "chunked") {
+      h.SetContentLength(int(size))
+      if err := w.commit(false); err != nil {
+        return 0, err
+      }
+    }
+  }
+  if w.WriterState != WriterStateBody || w.chunked || !w.sendsBody() || w.digest != nil {
+    return w.copyBuffered(src)
+  }
+  rf, ok := w.Writer.(io.ReaderFrom)
+  if !ok {
+    return w.copyBuffered(src)
+  }
+  if w.contentLength < 0 {
+    n, err := rf.ReadFrom(src)
+    w.written += int(n)
+    return n, err
+  }
+  // src is not trusted to be as long as the headers said, the connection
+  // would be left out of sync if it went past the Content-Length
+  n, err := rf.ReadFrom(io.LimitReader(src, int64(w.contentLength - w.written)))
+  w.written += int(n)
+  if err != nil {
+    return n, err
+  }
+  var b [1]byte
+  if m, _ := io.ReadFull(src, b[:]); m > 0 {
+    return n, fmt.Errorf("body longer than its Content-Length of %d", w.contentLength)
+  }
+  return n, nil
+}
+
+// copyBuffered copies src through Write, COPY_BUFFER_SIZE bytes at a time.
+func (w *Writer) copyBuffered(src io.Reader) (int64, error) {
+  buf := make([]byte, COPY_BUFFER_SIZE)
+  var written int64
+  for {
+    n, err := src.Read(buf)
+    if n > 0 {
+      m, werr := w.Write(buf[:n])
+      written += int64(m)
+      if werr != nil {
+        return written, werr
+      }
+    }
+    if errors.Is(err, io.EOF) {
+      return written, nil
+    }
+    if err != nil {
+      return written, err
+    }
+  }
+}
+
+// fileSize returns how much of src is left to read if it is a regular file.
+// It need not be an *os.File, io.Copy hands over one wrapped to hide its
+// WriteTo method.
+func fileSize(src io.Reader) (int64, bool) {
+  f, ok := src.(interface {
+    Stat() (os.FileInfo, error)
+    io.Seeker
+  })
+  if !ok {
+    return 0, false
+  }
+  info, err := f.Stat()
+  if err != nil || !info.Mode().IsRegular() {
+    return 0, false
+  }
+  offset, err := f.Seek(0, io.SeekCurrent)
+  if err != nil || offset > info.Size() {
+    return 0, false
+  }
+  return info.Size() - offset, true
+}
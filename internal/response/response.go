@@ -1,9 +1,13 @@
 package response
 
 import (
+	"errors"
 	"fmt"
+	"http-from-tcp/internal/cookie"
+	"http-from-tcp/internal/digest"
 	"http-from-tcp/internal/headers"
 	"io"
+	"slices"
 	"strconv"
 )
 
@@ -12,8 +16,58 @@ type StatusCode int
 type Writer struct {
   Writer io.Writer
   WriterState WriterState
+  // KeepAlive reports whether the connection can be reused after this
+  // response. The server sets it before calling the handler, and
+  // WriteHeaders clears it if the response asks for the connection to be
+  // closed or has no framing the client can use to find its end.
+  KeepAlive bool
+  // HttpVersion is the version of the request being responded to, "1.0" or
+  // "1.1". The status line matches it, and HTTP/1.0 clients never get a
+  // chunked body. Empty means 1.1.
+  HttpVersion string
+  // ExpectContinue is set by the server when the client is waiting for a
+  // 100 Continue before it sends the request body. WriteContinue clears it.
+  ExpectContinue bool
+  // ObsText decides whether header and trailer values written through the
+  // Writer may hold obs-text. Control characters are always refused.
+  ObsText headers.ObsTextPolicy
+  // Head is set by the server when responding to a HEAD request. The
+  // response is written as it would be for GET, but body bytes are
+  // discarded rather than sent.
+  Head bool
+  // AcceptsTrailers is set by the server when the request had a TE field
+  // with "trailers" in it. Declared trailers are dropped if it is not set,
+  // the client may not be expecting them.
+  AcceptsTrailers bool
+  // unchunked is set when a chunked response has to be sent to an
+  // HTTP/1.0 client, the body is written as is and delimited by closing
+  // the connection instead.
+  unchunked bool
+
+  // header, status and buf hold a response written through Write until its
+  // headers are sent, see framing.go
+  header *headers.Headers
+  status StatusCode // also set by WriteStatusLine
+  buf []byte
+  discarded int // body written through Write to a HEAD response
+  // chunked is set once headers announcing a chunked body are written.
+  chunked bool
+  // contentLength is the Content-Length the headers announced, -1 if none,
+  // and written how much of the body has been written so far.
+  contentLength int
+  written int
+  // trailerNames are the declared trailers, in canonical case, and trailer
+  // the values set for them, see trailers.go
+  trailerNames []string
+  trailer *headers.Headers
+  // digest hashes the body for its Content-Digest, see digest.go
+  digest *digest.Hash
 }
 
+// ErrBodyNotAllowed is returned when writing a body to a response whose
+// status does not allow one, 1xx, 204 and 304.
+var ErrBodyNotAllowed = errors.New("response status does not allow a body")
+
 type WriterState int
 const (
   WriterStateStatusLine = iota
@@ -22,136 +76,399 @@ const (
   WriterStateDone
 )
 
-const (
-  StatusCode200 = 200
-  StatusCode400 = 400
-  StatusCode500 = 500
-)
-
+// WriteStatusLine writes the status line with the registered reason phrase
+// for statusCode, or an empty one if the code is not registered.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+  return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes the status line with a reason phrase of
+// the handler's choosing. Clients are free to ignore it.
+//
+// A 1xx status line starts an interim response: once its headers are
+// written the Writer is back to expecting a status line, so any number of
+// them can precede the final response.
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reason string) error {
   if w.WriterState != WriterStateStatusLine {
     return fmt.Errorf("invalid, not in writer state")
   }
-  err := WriteStatusLine(w.Writer, statusCode)
+  if statusCode.Informational() {
+    if err := w.checkInterim(statusCode); err != nil {
+      return err
+    }
+  }
+  err := writeStatusLine(w.Writer, w.version(), statusCode, reason)
   if err != nil {
     return fmt.Errorf("error in writing status line: %w", err)
   }
+  w.status = statusCode
   w.WriterState = WriterStateHeaders
   return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+// WriteContinue sends the interim 100 Continue response a client sending
+// Expect: 100-continue waits for before it sends the request body. It does
+// nothing if the client isn't waiting for one.
+func (w *Writer) WriteContinue() error {
+  if !w.ExpectContinue {
+    return nil
+  }
+  if w.WriterState != WriterStateStatusLine {
+    return fmt.Errorf("invalid state, final response already started")
+  }
+  w.ExpectContinue = false
+  err := writeStatusLine(w.Writer, w.version(), StatusCode100, StatusText(StatusCode100))
+  if err != nil {
+    return fmt.Errorf("error in writing status line: %w", err)
+  }
+  _, err = w.Writer.Write([]byte(headers.CRLF))
+  return err
+}
+
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
   if w.WriterState != WriterStateHeaders {
     return fmt.Errorf("invalid state, not in header state")
   }
-  err := WriteHeaders(w.Writer, headers)
+  if w.status.Informational() {
+    return w.writeInterimHeaders(h)
+  }
+  // fields are added below, a nil h is as good as none
+  if h == nil {
+    h = headers.NewHeaders()
+  }
+  // the client was never told to send the body, so whether and when it
+  // arrives is anyone's guess, the connection can't be reused
+  if w.ExpectContinue {
+    w.KeepAlive = false
+  }
+  // a 204 response is never followed by a body, not even in principle, so
+  // it can't have framing either (RFC 9110 section 8.6, RFC 9112 section
+  // 6.1)
+  if w.status == StatusCode204 {
+    h.Del("Content-Length")
+    h.Del("Transfer-Encoding")
+  }
+  if w.HttpVersion == "1.0" && h.HasToken("Transfer-Encoding", "chunked") {
+    h.Del("Transfer-Encoding")
+    h.Del("Trailer")
+    w.unchunked = true
+  }
+  // a body that is still to come can only have its digest follow it
+  if w.digest != nil && !h.Has("Content-Digest") && h.HasToken("Transfer-Encoding", "chunked") {
+    w.DeclareTrailer("Content-Digest")
+  }
+  if err := w.declareTrailers(h); err != nil {
+    return fmt.Errorf("error in writing headers: %w", err)
+  }
+  if w.KeepAlive {
+    _, hasLength, err := h.ContentLength()
+    delimited := !w.sendsBody() || (hasLength && err == nil) || h.HasToken("Transfer-Encoding", "chunked")
+    if h.HasToken("Connection", "close") || !delimited {
+      w.KeepAlive = false
+    }
+  }
+  if !w.KeepAlive && !h.HasToken("Connection", "close") {
+    h.Set("Connection", "close")
+  }
+  if w.KeepAlive && w.HttpVersion == "1.0" && !h.HasToken("Connection", "keep-alive") {
+    h.Set("Connection", "keep-alive")
+  }
+  err := writeHeaders(w.Writer, h, w.ObsText)
   if err != nil {
     return fmt.Errorf("error in writing headers: %w", err)
   }
+  // HEAD and 304 responses may carry the framing a GET would have got,
+  // it only describes a body that is never sent
+  w.chunked = w.sendsBody() && h.HasToken("Transfer-Encoding", "chunked")
+  w.contentLength = -1
+  if length, ok, err := h.ContentLength(); ok && err == nil && w.sendsBody() {
+    w.contentLength = length
+  }
   w.WriterState = WriterStateBody
   return nil
 }
 
+// writeInterimHeaders ends an interim response with h and readies the
+// Writer for the next status line. Framing and connection fields are left
+// out, they only mean something in the final response, and h is not
+// changed so the handler can pass the final response's headers to an
+// Early Hints response.
+func (w *Writer) writeInterimHeaders(h *headers.Headers) error {
+  interim := headers.NewHeaders()
+  for key, value := range h.All() {
+    switch headers.CanonicalName(key) {
+    case "Content-Length", "Transfer-Encoding", "Connection", "Keep-Alive", "Trailer":
+      continue
+    }
+    interim.Add(key, value)
+  }
+  err := writeHeaders(w.Writer, interim, w.ObsText)
+  if err != nil {
+    return fmt.Errorf("error in writing headers: %w", err)
+  }
+  // the client may take any 100 Continue as the go-ahead for the body
+  if w.status == StatusCode100 {
+    w.ExpectContinue = false
+  }
+  w.status = 0
+  w.WriterState = WriterStateStatusLine
+  return nil
+}
+
+// checkInterim reports why an interim response with statusCode can't be
+// sent, if it can't.
+func (w *Writer) checkInterim(statusCode StatusCode) error {
+  // HTTP/1.0 has no 1xx responses, a client would take one for the final
+  // response (RFC 9110 section 15.2)
+  if w.HttpVersion == "1.0" {
+    return fmt.Errorf("can't send %d to an HTTP/1.0 client", statusCode)
+  }
+  // after a 101 the connection no longer speaks HTTP/1.1, which the Writer
+  // can't follow
+  if statusCode == StatusCode101 {
+    return fmt.Errorf("can't send 101 Switching Protocols")
+  }
+  return nil
+}
+
+// SetCookie adds a Set-Cookie field for c to h, the headers about to be
+// passed to WriteHeaders. Each cookie gets a field of its own. It fails if c
+// is not valid or the headers have already been written.
+func (w *Writer) SetCookie(h *headers.Headers, c *cookie.Cookie) error {
+  if w.WriterState > WriterStateHeaders {
+    return fmt.Errorf("invalid state, headers already written")
+  }
+  if err := c.Valid(); err != nil {
+    return fmt.Errorf("error setting cookie: %w", err)
+  }
+  h.Add("Set-Cookie", c.String())
+  return nil
+}
+
+// WriteBody writes p as is as part of the body. It can be called any number
+// of times, but not write past the Content-Length the headers announced.
 func (w *Writer) WriteBody(p []byte) (int, error) {
   if w.WriterState != WriterStateBody {
     return 0, fmt.Errorf("invalid state, not in body state")
   }
+  n, err := w.writeBody(p)
+  w.hashBody(p[:n])
+  return n, err
+}
+
+func (w *Writer) writeBody(p []byte) (int, error) {
+  if !w.sendsBody() {
+    return w.discard(p)
+  }
+  if w.contentLength >= 0 && w.written + len(p) > w.contentLength {
+    return 0, fmt.Errorf("body longer than its Content-Length of %d", w.contentLength)
+  }
   n, err := w.Writer.Write(p)
+  w.written += n
   if err != nil {
-    return 0, fmt.Errorf("error when writing body: %w", err)
+    return n, fmt.Errorf("error when writing body: %w", err)
   }
-  w.WriterState = WriterStateDone
   return n, nil
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-  totalWritten := 0
-  for len(p) > 0 {
-    var numBytesInChunk int = min(255, len(p))
-    var bytesInChunk byte = byte(numBytesInChunk)
-    fmt.Printf("bytes in chunk: %s\n", string(bytesInChunk))
-    fmt.Printf("length of p: %d\n", len(p))
-    fmt.Printf("result: %d\n", min(255, byte(len(p))))
-    n, err := w.Writer.Write([]byte{bytesInChunk, headers.CRLF[0], headers.CRLF[1]})
-    if err != nil {
-      return 0, err
-    }
-    n, err = w.Writer.Write(p[:bytesInChunk])
-    fmt.Printf("Writing chunk of %d bytes\n", n)
-    fmt.Printf("Wrote data: %s\n", string(p[:n]))
-    if err != nil {
-      return 0, err
-    }
-    p = p[n:]
+// ChunkExtension is a chunk-ext, name=value metadata attached to a single
+// chunk (RFC 9112 section 7.1.1). Value may be empty for an extension
+// without one.
+type ChunkExtension struct {
+  Name string
+  Value string
+}
 
-    n, err = w.Writer.Write([]byte(headers.CRLF))
-    fmt.Printf("wrote CRLF\n")
-    if err != nil {
-      return 0, err
-    }
+// WriteChunkedBody writes p as a single chunk of a chunked body and returns
+// the number of bytes of p written. Writing an empty p does nothing, a
+// zero-length chunk would end the body.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+  return w.WriteChunkedBodyWithExtensions(p)
+}
 
-    totalWritten += n
+// WriteChunkedBodyWithExtensions is WriteChunkedBody with chunk extensions
+// added to the chunk. Recipients are free to ignore them.
+func (w *Writer) WriteChunkedBodyWithExtensions(p []byte, extensions ...ChunkExtension) (int, error) {
+  if w.WriterState != WriterStateBody {
+    return 0, fmt.Errorf("invalid state, not in body state")
   }
-  return totalWritten, nil
+  n, err := w.writeChunk(p, extensions)
+  w.hashBody(p[:n])
+  return n, err
 }
 
-func (w *Writer) WriteChunkedBodyDone(trailers headers.Headers) (int, error) {
-  _, err := w.Writer.Write([]byte{0x00})
+func (w *Writer) writeChunk(p []byte, extensions []ChunkExtension) (int, error) {
+  if !w.sendsBody() {
+    return w.discard(p)
+  }
+  if w.unchunked {
+    return w.Writer.Write(p)
+  }
+  if len(p) == 0 {
+    return 0, nil
+  }
+  ext, err := formatChunkExtensions(extensions)
   if err != nil {
     return 0, err
   }
-  _, err = w.Writer.Write([]byte(headers.CRLF))
+  // written in one go so that the chunk does not trickle out in pieces
+  chunk := make([]byte, 0, len(p) + len(ext) + 32)
+  chunk = strconv.AppendInt(chunk, int64(len(p)), 16)
+  chunk = append(chunk, ext...)
+  chunk = append(chunk, headers.CRLF...)
+  chunk = append(chunk, p...)
+  chunk = append(chunk, headers.CRLF...)
+  _, err = w.Writer.Write(chunk)
+  if err != nil {
+    return 0, fmt.Errorf("error writing chunk: %w", err)
+  }
+  return len(p), nil
+}
+
+// WriteChunkedBodyDone ends a chunked body with the last chunk and the
+// trailer section. The fields of trailers, which may be nil, are added to
+// those set with SetTrailer and have to be declared the same way. Nothing
+// is written if one of them isn't.
+func (w *Writer) WriteChunkedBodyDone(trailers *headers.Headers) (int, error) {
+  if w.WriterState != WriterStateBody {
+    return 0, fmt.Errorf("invalid state, not in body state")
+  }
+  for name, value := range trailers.All() {
+    if err := w.checkTrailer(name, value); err != nil {
+      return 0, err
+    }
+  }
+  for name := range trailers.All() {
+    if w.trailer == nil {
+      w.trailer = headers.NewHeaders()
+    }
+    w.trailer.Del(name)
+  }
+  for name, value := range trailers.All() {
+    w.trailer.Add(name, value)
+  }
+  if w.digest != nil && !w.trailer.Has("Content-Digest") && slices.Contains(w.trailerNames, "Content-Digest") {
+    w.SetTrailer("Content-Digest", w.digest.Field())
+  }
+  w.WriterState = WriterStateDone
+  if !w.sendsBody() {
+    return 0, nil
+  }
+  if w.unchunked { // the end of the body is the end of the connection
+    return 0, nil
+  }
+  _, err := w.Writer.Write([]byte("0" + headers.CRLF))
   if err != nil {
     return 0, err
   }
-  w.WriteTrailers(trailers)
-  _, err = w.Writer.Write([]byte(headers.CRLF))
+  trailers = w.trailer
+  if !w.AcceptsTrailers {
+    trailers = nil
+  }
+  err = w.WriteTrailers(trailers)
   if err != nil {
     return 0, err
   }
-  fmt.Printf("wrote final chunk\n")
   return 0, nil
 }
 
+func formatChunkExtensions(extensions []ChunkExtension) (string, error) {
+  s := ""
+  for _, ext := range extensions {
+    if !headers.IsToken(ext.Name) {
+      return "", fmt.Errorf("invalid chunk extension name %q", ext.Name)
+    }
+    s += ";" + ext.Name
+    switch {
+    case ext.Value == "":
+    case headers.IsToken(ext.Value):
+      s += "=" + ext.Value
+    default:
+      if err := headers.ValidateValue(ext.Value, headers.ObsTextReject); err != nil {
+        return "", fmt.Errorf("invalid chunk extension %s: %w", ext.Name, err)
+      }
+      s += "=" + headers.Quote(ext.Value)
+    }
+  }
+  return s, nil
+}
+
+// sendsBody reports whether the body written is actually sent, which it
+// isn't for HEAD requests and for statuses that don't allow one.
+func (w *Writer) sendsBody() bool {
+  return !w.Head && !bodyless(w.status)
+}
+
+// discard stands in for writing p to a response that has no body. It is
+// accepted for HEAD requests, the handler doesn't have to know, but a
+// handler writing a body for a status that doesn't allow one made a
+// mistake.
+func (w *Writer) discard(p []byte) (int, error) {
+  if bodyless(w.status) && len(p) > 0 {
+    return 0, ErrBodyNotAllowed
+  }
+  return len(p), nil
+}
+
+// bodyless reports whether responses with status code never have a body.
+func bodyless(code StatusCode) bool {
+  return code.Informational() || code == StatusCode204 || code == StatusCode304
+}
+
+func (w *Writer) version() string {
+  if w.HttpVersion == "" {
+    return "1.1"
+  }
+  return w.HttpVersion
+}
+
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-  reasonPhrase := ""
-  version := "HTTP/1.1"
-  switch statusCode {
-  case StatusCode200:
-    reasonPhrase = version + " " + strconv.Itoa(StatusCode200) + " " + "OK"
-  case StatusCode400:
-    reasonPhrase = version + " " + strconv.Itoa(StatusCode400) + " " + "Bad Request"
-  case StatusCode500:
-    reasonPhrase = version + " " + strconv.Itoa(StatusCode500) + " " + "Internal Server Error"
-  default:
-    reasonPhrase = version + " " + strconv.Itoa(int(statusCode)) + " "
-  }
-  reasonPhrase += "\r\n"
-  // fmt.Printf("%s", reasonPhrase)
-  _, err := w.Write([]byte(reasonPhrase));
+  return writeStatusLine(w, "1.1", statusCode, StatusText(statusCode))
+}
+
+func writeStatusLine(w io.Writer, httpVersion string, statusCode StatusCode, reason string) error {
+  if !statusCode.Valid() {
+    return fmt.Errorf("invalid status code %d, not three digits", statusCode)
+  }
+  if !validReasonPhrase(reason) {
+    return fmt.Errorf("invalid reason phrase %q", reason)
+  }
+  // the space before the reason phrase is there even if it is empty
+  statusLine := "HTTP/" + httpVersion + " " + strconv.Itoa(int(statusCode)) + " " + reason + headers.CRLF
+  _, err := w.Write([]byte(statusLine))
   return err
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+func GetDefaultHeaders(contentLen int) *headers.Headers {
   h := headers.NewHeaders()
-  h.Set("Content-Length", strconv.Itoa(contentLen))
-  h.Set("Connection", "close")
+  h.SetContentLength(contentLen)
   h.Set("Content-Type", "text/html")
   return h
 }
 
-func WriteHeaders(w io.Writer, headers headers.Headers) error {
-  h := ""
-  for key, value := range headers {
-    str := fmt.Sprintf("%s: %s\r\n", key, value)
-    h += str
+// WriteHeaders writes the fields in the order they were added, with their
+// names in canonical case, followed by the empty line ending the section.
+// Nothing is written if a field would not survive the trip, a value holding
+// a CR or LF would otherwise let it add fields or split the response.
+func WriteHeaders(w io.Writer, h *headers.Headers) error {
+  return writeHeaders(w, h, headers.ObsTextAllow)
+}
+
+func writeHeaders(w io.Writer, h *headers.Headers, policy headers.ObsTextPolicy) error {
+  s := ""
+  for key, value := range h.All() {
+    if err := headers.ValidateField(key, value, policy); err != nil {
+      return err
+    }
+    s += fmt.Sprintf("%s: %s\r\n", headers.CanonicalName(key), value)
   }
-  h += "\r\n"
-  // fmt.Printf("%s", h)
-  _, err := w.Write([]byte(h))
+  s += headers.CRLF
+  _, err := w.Write([]byte(s))
   return err
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-  return w.WriteHeaders(h)
-}
\ No newline at end of file
+// WriteTrailers writes the trailer section ending a chunked body, checked
+// the same way as the headers.
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+  return writeHeaders(w.Writer, h, w.ObsText)
+}
@@ -0,0 +1,683 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"http-from-tcp/internal/digest"
+	"http-from-tcp/internal/headers"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	for _, tc := range []struct {
+		code       StatusCode
+		statusLine string
+	}{
+		{StatusCode200, "HTTP/1.1 200 OK\r\n"},
+		{StatusCode201, "HTTP/1.1 201 Created\r\n"},
+		{StatusCode204, "HTTP/1.1 204 No Content\r\n"},
+		{StatusCode301, "HTTP/1.1 301 Moved Permanently\r\n"},
+		{StatusCode304, "HTTP/1.1 304 Not Modified\r\n"},
+		{StatusCode404, "HTTP/1.1 404 Not Found\r\n"},
+		{StatusCode429, "HTTP/1.1 429 Too Many Requests\r\n"},
+		{StatusCode503, "HTTP/1.1 503 Service Unavailable\r\n"},
+		// unregistered codes keep the space before their empty reason phrase
+		{599, "HTTP/1.1 599 \r\n"},
+	} {
+		var b bytes.Buffer
+		w := &Writer{Writer: &b}
+		require.NoError(t, w.WriteStatusLine(tc.code))
+		assert.Equal(t, tc.statusLine, b.String())
+	}
+
+	// Test: HTTP/1.0 status line
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, HttpVersion: "1.0"}
+	require.NoError(t, w.WriteStatusLine(StatusCode404))
+	assert.Equal(t, "HTTP/1.0 404 Not Found\r\n", b.String())
+
+	// Test: Codes that are not three digits
+	for _, code := range []StatusCode{0, 99, 1000, -200} {
+		b.Reset()
+		w = &Writer{Writer: &b}
+		assert.Error(t, w.WriteStatusLine(code))
+		assert.Empty(t, b.String())
+		assert.Equal(t, WriterStateStatusLine, int(w.WriterState))
+	}
+}
+
+func TestWriteStatusLineWithReason(t *testing.T) {
+	var b bytes.Buffer
+	w := &Writer{Writer: &b}
+	require.NoError(t, w.WriteStatusLineWithReason(StatusCode200, "Everything\tIs Fine"))
+	assert.Equal(t, "HTTP/1.1 200 Everything\tIs Fine\r\n", b.String())
+
+	b.Reset()
+	w = &Writer{Writer: &b}
+	assert.Error(t, w.WriteStatusLineWithReason(StatusCode200, "OK\r\nX-Injected: 1"))
+	assert.Empty(t, b.String())
+}
+
+func TestStatusText(t *testing.T) {
+	// 418 is reserved but unused
+	assert.Equal(t, "", StatusText(418))
+	assert.Equal(t, "Content Too Large", StatusText(StatusCode413))
+	assert.Equal(t, "Network Authentication Required", StatusText(StatusCode511))
+	assert.Equal(t, "", StatusText(299))
+}
+
+// chunkedWriter returns a Writer that has its headers written and is ready
+// for a chunked body.
+func chunkedWriter(b *bytes.Buffer) *Writer {
+	return &Writer{Writer: b, WriterState: WriterStateBody}
+}
+
+func TestWriteChunkedBody(t *testing.T) {
+	var b bytes.Buffer
+	w := chunkedWriter(&b)
+	var sent []byte
+	for _, size := range []int{1, 15, 16, 255, 256, 4096, 100000} {
+		p := bytes.Repeat([]byte{byte('a' + size%26)}, size)
+		n, err := w.WriteChunkedBody(p)
+		require.NoError(t, err)
+		assert.Equal(t, size, n)
+		sent = append(sent, p...)
+	}
+	// empty writes must not end the body early
+	n, err := w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = w.WriteChunkedBodyDone(nil)
+	require.NoError(t, err)
+	assert.Equal(t, WriterStateDone, int(w.WriterState))
+
+	assert.True(t, strings.HasPrefix(b.String(), "1\r\nb\r\nf\r\n"))
+	assert.Contains(t, b.String(), "\r\n100\r\n")
+	assert.Contains(t, b.String(), "\r\n186a0\r\n")
+	assert.True(t, strings.HasSuffix(b.String(), "\r\n0\r\n\r\n"))
+
+	reader := bufio.NewReader(&b)
+	received, err := io.ReadAll(httputil.NewChunkedReader(reader))
+	require.NoError(t, err)
+	assert.Equal(t, sent, received)
+	// all that is left after the last chunk is the empty trailer section
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", string(rest))
+}
+
+func TestWriteChunkedBodyWithExtensions(t *testing.T) {
+	var b bytes.Buffer
+	w := chunkedWriter(&b)
+	n, err := w.WriteChunkedBodyWithExtensions([]byte("hello"),
+		ChunkExtension{Name: "signature", Value: "abc"},
+		ChunkExtension{Name: "note", Value: `say "hi"`},
+		ChunkExtension{Name: "last"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "5;signature=abc;note=\"say \\\"hi\\\"\";last\r\nhello\r\n", b.String())
+
+	received, err := io.ReadAll(httputil.NewChunkedReader(io.MultiReader(&b, strings.NewReader("0\r\n"))))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(received))
+
+	// Test: Invalid extensions write nothing
+	b.Reset()
+	_, err = w.WriteChunkedBodyWithExtensions([]byte("hello"), ChunkExtension{Name: "bad name"})
+	assert.Error(t, err)
+	_, err = w.WriteChunkedBodyWithExtensions([]byte("hello"), ChunkExtension{Name: "a", Value: "x\r\ny"})
+	assert.Error(t, err)
+	assert.Empty(t, b.String())
+}
+
+func TestWriteChunkedBodyDone(t *testing.T) {
+	var b bytes.Buffer
+	w := chunkedWriter(&b)
+	w.AcceptsTrailers = true
+	w.trailerNames = []string{"X-Checksum"}
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("x-checksum", "abc")
+	_, err = w.WriteChunkedBodyDone(trailers)
+	require.NoError(t, err)
+	assert.Equal(t, "5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n", b.String())
+
+	// Test: Nothing can be written once the body is done
+	_, err = w.WriteChunkedBody([]byte("more"))
+	assert.Error(t, err)
+	_, err = w.WriteChunkedBodyDone(nil)
+	assert.Error(t, err)
+
+	// Test: Or before the headers are
+	w = &Writer{Writer: &b}
+	_, err = w.WriteChunkedBody([]byte("early"))
+	assert.Error(t, err)
+}
+
+func TestWriteAutomaticFraming(t *testing.T) {
+	// Test: A small body gets a Content-Length
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true}
+	w.Header().Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteHeader(StatusCode201))
+	for _, part := range []string{"hello", ", ", "world"} {
+		n, err := io.WriteString(w, part)
+		require.NoError(t, err)
+		assert.Equal(t, len(part), n)
+	}
+	assert.Empty(t, b.String(), "nothing is sent before the handler is done")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 201 Created\r\nContent-Type: text/plain\r\nContent-Length: 12\r\n\r\nhello, world", b.String())
+	assert.True(t, w.KeepAlive)
+
+	// Test: Writing nothing at all is an empty 200
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", b.String())
+
+	// Test: A large body switches to chunked
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	body := bytes.Repeat([]byte("0123456789"), MAX_BUFFERED_BODY_SIZE/5)
+	for i := 0; i < len(body); i += 1000 {
+		_, err := w.Write(body[i:min(i+1000, len(body))])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Finish())
+	reader := bufio.NewReader(&b)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	fields, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Transfer-Encoding: chunked\r\n", fields)
+	blank, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+	received, err := io.ReadAll(httputil.NewChunkedReader(reader))
+	require.NoError(t, err)
+	assert.Equal(t, body, received)
+	assert.True(t, w.KeepAlive)
+
+	// Test: A Content-Length set by the handler is kept
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	w.Header().SetContentLength(len(body))
+	_, err = w.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body), b.String())
+}
+
+func TestWriteFlush(t *testing.T) {
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true}
+	_, err := w.Write([]byte("first"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nfirst\r\n", b.String())
+
+	// later changes to the headers have no effect
+	w.Header().Set("X-Late", "1")
+	_, err = w.Write([]byte("second"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nfirst\r\n6\r\nsecond\r\n0\r\n\r\n", b.String())
+
+	// Test: HTTP/1.0 clients get the body as is, delimited by closing
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, HttpVersion: "1.0"}
+	_, err = w.Write([]byte("old"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nold", b.String())
+	assert.False(t, w.KeepAlive)
+}
+
+func TestWriteExplicitFraming(t *testing.T) {
+	// Test: Multiple writes after explicit headers
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("!"))
+	assert.Error(t, err, "past the Content-Length")
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive)
+
+	// Test: A body shorter than its Content-Length closes the connection
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err = w.Write([]byte("short"))
+	require.NoError(t, err)
+	assert.Error(t, w.Finish())
+	assert.False(t, w.KeepAlive)
+
+	// Test: Writes become chunks when the headers ask for chunked
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n", b.String())
+
+	// Test: No headers at all is a close-delimited body
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(nil))
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nabc", b.String())
+	assert.False(t, w.KeepAlive)
+
+	// Test: Status set twice
+	w = &Writer{Writer: &b}
+	require.NoError(t, w.WriteHeader(StatusCode404))
+	assert.Error(t, w.WriteHeader(StatusCode200))
+}
+
+func TestHeadResponse(t *testing.T) {
+	// Test: Automatic framing reports the length a GET would get
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true, Head: true}
+	body := bytes.Repeat([]byte("x"), 3*MAX_BUFFERED_BODY_SIZE)
+	n, err := w.Write(body)
+	require.NoError(t, err)
+	assert.Equal(t, len(body), n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", len(body)), b.String())
+	assert.True(t, w.KeepAlive)
+
+	// Test: Explicit headers are sent, the body isn't
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, Head: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/html\r\n\r\n", b.String())
+	assert.True(t, w.KeepAlive)
+
+	// Test: Nor is a chunked one
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, Head: true}
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", b.String())
+}
+
+func TestBodylessStatus(t *testing.T) {
+	// Test: 204 has its framing removed and refuses a body
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode204))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nContent-Type: text/html\r\n\r\n", b.String())
+	assert.True(t, w.KeepAlive)
+
+	// Test: 304 keeps the Content-Length of the representation
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode304))
+	h := headers.NewHeaders()
+	h.Set("ETag", `"v1"`)
+	h.SetContentLength(1234)
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nEtag: \"v1\"\r\nContent-Length: 1234\r\n\r\n", b.String())
+	assert.True(t, w.KeepAlive)
+
+	// Test: Automatic framing adds none
+	for _, code := range []StatusCode{StatusCode204, StatusCode304} {
+		b.Reset()
+		w = &Writer{Writer: &b, KeepAlive: true}
+		require.NoError(t, w.WriteHeader(code))
+		_, err = w.Write([]byte("body"))
+		assert.ErrorIs(t, err, ErrBodyNotAllowed)
+		_, err = w.Write(nil)
+		assert.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 %d %s\r\n\r\n", code, StatusText(code)), b.String())
+		assert.True(t, w.KeepAlive)
+	}
+}
+
+func TestInterimResponses(t *testing.T) {
+	// Test: Any number of 1xx responses before the final one
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true}
+	hints := headers.NewHeaders()
+	hints.Add("Link", "</style.css>; rel=preload; as=style")
+	hints.SetContentLength(5)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, w.WriteStatusLine(StatusCode103))
+		require.NoError(t, w.WriteHeaders(hints))
+		assert.Equal(t, WriterStateStatusLine, int(w.WriterState))
+	}
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(hints))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	interim := "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"
+	assert.Equal(t, interim+interim+"HTTP/1.1 200 OK\r\nLink: </style.css>; rel=preload; as=style\r\nContent-Length: 5\r\n\r\nhello", b.String())
+	assert.True(t, w.KeepAlive)
+
+	// Test: A 1xx status through WriteHeader is sent right away
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	w.Header().Add("Link", "</app.js>; rel=preload; as=script")
+	require.NoError(t, w.WriteHeader(StatusCode103))
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nLink: </app.js>; rel=preload; as=script\r\n\r\n", b.String())
+	require.NoError(t, w.WriteHeader(StatusCode201))
+	_, err = io.WriteString(w, "made")
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nLink: </app.js>; rel=preload; as=script\r\n\r\n"+
+		"HTTP/1.1 201 Created\r\nLink: </app.js>; rel=preload; as=script\r\nContent-Length: 4\r\n\r\nmade", b.String())
+
+	// Test: A 100 Continue satisfies the expectation
+	b.Reset()
+	w = &Writer{Writer: &b, ExpectContinue: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode100))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.False(t, w.ExpectContinue)
+	require.NoError(t, w.WriteContinue())
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", b.String())
+
+	// Test: HTTP/1.0 clients get none
+	b.Reset()
+	w = &Writer{Writer: &b, HttpVersion: "1.0"}
+	assert.Error(t, w.WriteStatusLine(StatusCode103))
+	require.NoError(t, w.WriteHeader(StatusCode103))
+	assert.Equal(t, "", b.String())
+
+	// Test: 101 is refused
+	w = &Writer{Writer: &b}
+	assert.Error(t, w.WriteStatusLine(StatusCode101))
+	assert.Error(t, w.WriteHeader(StatusCode101))
+	assert.Equal(t, "", b.String())
+}
+
+func TestTrailers(t *testing.T) {
+	newWriter := func(b *bytes.Buffer, acceptsTrailers bool) *Writer {
+		w := &Writer{Writer: b, KeepAlive: true, AcceptsTrailers: acceptsTrailers}
+		require.NoError(t, w.WriteStatusLine(StatusCode200))
+		return w
+	}
+	chunked := func() *headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		return h
+	}
+
+	// Test: Declared trailers are announced and sent after the last chunk
+	var b bytes.Buffer
+	w := newWriter(&b, true)
+	require.NoError(t, w.DeclareTrailer("x-checksum"))
+	h := chunked()
+	h.Set("Trailer", "X-Length")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Length", "5")
+	_, err = w.WriteChunkedBodyDone(trailers)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum, X-Length\r\n\r\n"+
+		"5\r\nhello\r\n0\r\nX-Checksum: abc\r\nX-Length: 5\r\n\r\n", b.String())
+	resp, err := http.ReadResponse(bufio.NewReader(&b), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+
+	// Test: Forbidden and undeclared fields are refused
+	b.Reset()
+	w = newWriter(&b, true)
+	for _, name := range []string{"Content-Length", "transfer-encoding", "Host", "Set-Cookie", "Trailer", "bad name"} {
+		assert.Error(t, w.DeclareTrailer(name), name)
+	}
+	h = chunked()
+	h.Set("Trailer", "Content-Type")
+	assert.Error(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteHeaders(chunked()))
+	assert.Error(t, w.SetTrailer("X-Checksum", "abc"))
+	assert.Error(t, w.DeclareTrailer("X-Checksum"), "headers already written")
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	_, err = w.WriteChunkedBodyDone(trailers)
+	assert.Error(t, err)
+	assert.Equal(t, WriterStateBody, int(w.WriterState))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", b.String())
+
+	// Test: Dropped if the client doesn't accept them
+	b.Reset()
+	w = newWriter(&b, false)
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	require.NoError(t, w.WriteHeaders(chunked()))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", b.String())
+
+	// Test: Or if the body isn't chunked
+	b.Reset()
+	w = newWriter(&b, true)
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nContent-Type: text/html\r\n\r\n", b.String())
+
+	// Test: Automatic framing chunks a small body for them
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, AcceptsTrailers: true}
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	_, err = io.WriteString(w, "hello")
+	require.NoError(t, err)
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n"+
+		"5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n", b.String())
+}
+
+func TestDigestBody(t *testing.T) {
+	const helloSHA256 = "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:"
+
+	// Test: A buffered body has its digest in the headers
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.DigestBody(digest.SHA256))
+	_, err := io.WriteString(w, "hello")
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Digest: "+helloSHA256+"\r\n\r\nhello", b.String())
+
+	// Test: As does a HEAD response, with the digest a GET would get
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, Head: true}
+	require.NoError(t, w.DigestBody(digest.SHA256))
+	_, err = io.WriteString(w, "hello")
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Digest: "+helloSHA256+"\r\n\r\n", b.String())
+
+	// Test: A streamed one has it in a trailer
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, AcceptsTrailers: true}
+	require.NoError(t, w.DigestBody(digest.SHA256))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(h))
+	for _, part := range []string{"hel", "lo"} {
+		_, err = w.WriteChunkedBody([]byte(part))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: Content-Digest\r\n\r\n"+
+		"3\r\nhel\r\n2\r\nlo\r\n0\r\nContent-Digest: "+helloSHA256+"\r\n\r\n", b.String())
+
+	// Test: Including one that outgrew the buffer
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, AcceptsTrailers: true}
+	require.NoError(t, w.DigestBody())
+	body := bytes.Repeat([]byte("x"), 2*MAX_BUFFERED_BODY_SIZE)
+	_, err = w.Write(body[:MAX_BUFFERED_BODY_SIZE+1])
+	require.NoError(t, err)
+	_, err = w.Write(body[MAX_BUFFERED_BODY_SIZE+1:])
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	resp, err := http.ReadResponse(bufio.NewReader(&b), nil)
+	require.NoError(t, err)
+	received, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, received)
+	expected, err := digest.New()
+	require.NoError(t, err)
+	expected.Write(body)
+	assert.Equal(t, expected.Field(), resp.Trailer.Get("Content-Digest"))
+
+	// Test: Too late once the body is started
+	b.Reset()
+	w = &Writer{Writer: &b}
+	_, err = io.WriteString(w, "hello")
+	require.NoError(t, err)
+	assert.Error(t, w.DigestBody())
+	assert.Error(t, (&Writer{Writer: &b}).DigestBody("md5"))
+}
+
+func TestHijack(t *testing.T) {
+	// Test: Not without a connection to hand over
+	var b bytes.Buffer
+	w := &Writer{Writer: &b}
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+
+	// Test: Nor once the final response is started
+	_, err = io.WriteString(w, "hello")
+	require.NoError(t, err)
+	_, _, err = w.Hijack()
+	assert.Error(t, err)
+}
+
+func TestReadFrom(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+	open := func(offset int64) *os.File {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+		_, err = f.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		return f
+	}
+
+	// Test: A file gets its size as Content-Length and is copied as is
+	var b bytes.Buffer
+	w := &Writer{Writer: &b, KeepAlive: true}
+	n, err := io.Copy(w, open(6))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)-6), n)
+	assert.NotEmpty(t, b.String(), "sent right away")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(content)-6, content[6:]), b.String())
+	assert.True(t, w.KeepAlive)
+
+	// Test: Other readers are buffered like any other body
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	_, err = w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", b.String())
+
+	// Test: Chunked bodies are copied in chunks
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	w.Header().Set("Transfer-Encoding", "chunked")
+	_, err = io.Copy(w, open(0))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	resp, err := http.ReadResponse(bufio.NewReader(&b), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	received, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, content, received)
+
+	// Test: As are digested ones
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, AcceptsTrailers: true}
+	require.NoError(t, w.DigestBody(digest.SHA256))
+	_, err = io.Copy(w, open(0))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	resp, err = http.ReadResponse(bufio.NewReader(&b), nil)
+	require.NoError(t, err)
+	received, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, content, received)
+	expected, err := digest.New(digest.SHA256)
+	require.NoError(t, err)
+	expected.Write(content)
+	assert.Equal(t, expected.Field(), resp.Trailer.Get("Content-Digest"))
+
+	// Test: HEAD responses get the size without the body
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true, Head: true}
+	_, err = io.Copy(w, open(0))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", len(content)), b.String())
+
+	// Test: A file longer than the Content-Length the handler set
+	b.Reset()
+	w = &Writer{Writer: &b, KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCode200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	n, err = io.Copy(w, open(0))
+	assert.Error(t, err)
+	assert.Equal(t, int64(10), n)
+}
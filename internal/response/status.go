@@ -0,0 +1,171 @@
+package response
+
+// Status codes registered with IANA, see
+// https://www.iana.org/assignments/http-status-codes. 306 and 418 are
+// reserved but unused and have no constant.
+const (
+  StatusCode100 StatusCode = 100 // Continue
+  StatusCode101 StatusCode = 101 // Switching Protocols
+  StatusCode102 StatusCode = 102 // Processing
+  StatusCode103 StatusCode = 103 // Early Hints
+
+  StatusCode200 StatusCode = 200 // OK
+  StatusCode201 StatusCode = 201 // Created
+  StatusCode202 StatusCode = 202 // Accepted
+  StatusCode203 StatusCode = 203 // Non-Authoritative Information
+  StatusCode204 StatusCode = 204 // No Content
+  StatusCode205 StatusCode = 205 // Reset Content
+  StatusCode206 StatusCode = 206 // Partial Content
+  StatusCode207 StatusCode = 207 // Multi-Status
+  StatusCode208 StatusCode = 208 // Already Reported
+  StatusCode226 StatusCode = 226 // IM Used
+
+  StatusCode300 StatusCode = 300 // Multiple Choices
+  StatusCode301 StatusCode = 301 // Moved Permanently
+  StatusCode302 StatusCode = 302 // Found
+  StatusCode303 StatusCode = 303 // See Other
+  StatusCode304 StatusCode = 304 // Not Modified
+  StatusCode305 StatusCode = 305 // Use Proxy
+  StatusCode307 StatusCode = 307 // Temporary Redirect
+  StatusCode308 StatusCode = 308 // Permanent Redirect
+
+  StatusCode400 StatusCode = 400 // Bad Request
+  StatusCode401 StatusCode = 401 // Unauthorized
+  StatusCode402 StatusCode = 402 // Payment Required
+  StatusCode403 StatusCode = 403 // Forbidden
+  StatusCode404 StatusCode = 404 // Not Found
+  StatusCode405 StatusCode = 405 // Method Not Allowed
+  StatusCode406 StatusCode = 406 // Not Acceptable
+  StatusCode407 StatusCode = 407 // Proxy Authentication Required
+  StatusCode408 StatusCode = 408 // Request Timeout
+  StatusCode409 StatusCode = 409 // Conflict
+  StatusCode410 StatusCode = 410 // Gone
+  StatusCode411 StatusCode = 411 // Length Required
+  StatusCode412 StatusCode = 412 // Precondition Failed
+  StatusCode413 StatusCode = 413 // Content Too Large
+  StatusCode414 StatusCode = 414 // URI Too Long
+  StatusCode415 StatusCode = 415 // Unsupported Media Type
+  StatusCode416 StatusCode = 416 // Range Not Satisfiable
+  StatusCode417 StatusCode = 417 // Expectation Failed
+  StatusCode421 StatusCode = 421 // Misdirected Request
+  StatusCode422 StatusCode = 422 // Unprocessable Content
+  StatusCode423 StatusCode = 423 // Locked
+  StatusCode424 StatusCode = 424 // Failed Dependency
+  StatusCode425 StatusCode = 425 // Too Early
+  StatusCode426 StatusCode = 426 // Upgrade Required
+  StatusCode428 StatusCode = 428 // Precondition Required
+  StatusCode429 StatusCode = 429 // Too Many Requests
+  StatusCode431 StatusCode = 431 // Request Header Fields Too Large
+  StatusCode451 StatusCode = 451 // Unavailable For Legal Reasons
+
+  StatusCode500 StatusCode = 500 // Internal Server Error
+  StatusCode501 StatusCode = 501 // Not Implemented
+  StatusCode502 StatusCode = 502 // Bad Gateway
+  StatusCode503 StatusCode = 503 // Service Unavailable
+  StatusCode504 StatusCode = 504 // Gateway Timeout
+  StatusCode505 StatusCode = 505 // HTTP Version Not Supported
+  StatusCode506 StatusCode = 506 // Variant Also Negotiates
+  StatusCode507 StatusCode = 507 // Insufficient Storage
+  StatusCode508 StatusCode = 508 // Loop Detected
+  StatusCode510 StatusCode = 510 // Not Extended
+  StatusCode511 StatusCode = 511 // Network Authentication Required
+)
+
+var statusText = map[StatusCode]string{
+  StatusCode100: "Continue",
+  StatusCode101: "Switching Protocols",
+  StatusCode102: "Processing",
+  StatusCode103: "Early Hints",
+
+  StatusCode200: "OK",
+  StatusCode201: "Created",
+  StatusCode202: "Accepted",
+  StatusCode203: "Non-Authoritative Information",
+  StatusCode204: "No Content",
+  StatusCode205: "Reset Content",
+  StatusCode206: "Partial Content",
+  StatusCode207: "Multi-Status",
+  StatusCode208: "Already Reported",
+  StatusCode226: "IM Used",
+
+  StatusCode300: "Multiple Choices",
+  StatusCode301: "Moved Permanently",
+  StatusCode302: "Found",
+  StatusCode303: "See Other",
+  StatusCode304: "Not Modified",
+  StatusCode305: "Use Proxy",
+  StatusCode307: "Temporary Redirect",
+  StatusCode308: "Permanent Redirect",
+
+  StatusCode400: "Bad Request",
+  StatusCode401: "Unauthorized",
+  StatusCode402: "Payment Required",
+  StatusCode403: "Forbidden",
+  StatusCode404: "Not Found",
+  StatusCode405: "Method Not Allowed",
+  StatusCode406: "Not Acceptable",
+  StatusCode407: "Proxy Authentication Required",
+  StatusCode408: "Request Timeout",
+  StatusCode409: "Conflict",
+  StatusCode410: "Gone",
+  StatusCode411: "Length Required",
+  StatusCode412: "Precondition Failed",
+  StatusCode413: "Content Too Large",
+  StatusCode414: "URI Too Long",
+  StatusCode415: "Unsupported Media Type",
+  StatusCode416: "Range Not Satisfiable",
+  StatusCode417: "Expectation Failed",
+  StatusCode421: "Misdirected Request",
+  StatusCode422: "Unprocessable Content",
+  StatusCode423: "Locked",
+  StatusCode424: "Failed Dependency",
+  StatusCode425: "Too Early",
+  StatusCode426: "Upgrade Required",
+  StatusCode428: "Precondition Required",
+  StatusCode429: "Too Many Requests",
+  StatusCode431: "Request Header Fields Too Large",
+  StatusCode451: "Unavailable For Legal Reasons",
+
+  StatusCode500: "Internal Server Error",
+  StatusCode501: "Not Implemented",
+  StatusCode502: "Bad Gateway",
+  StatusCode503: "Service Unavailable",
+  StatusCode504: "Gateway Timeout",
+  StatusCode505: "HTTP Version Not Supported",
+  StatusCode506: "Variant Also Negotiates",
+  StatusCode507: "Insufficient Storage",
+  StatusCode508: "Loop Detected",
+  StatusCode510: "Not Extended",
+  StatusCode511: "Network Authentication Required",
+}
+
+// StatusText returns the reason phrase registered for code, or "" if the
+// code is not registered.
+func StatusText(code StatusCode) string {
+  return statusText[code]
+}
+
+// Valid reports whether code is a three digit number. Clients have to
+// handle codes they don't know by their first digit, so unregistered codes
+// can still be sent.
+func (code StatusCode) Valid() bool {
+  return code >= 100 && code <= 999
+}
+
+// Informational reports whether code is a 1xx code, an interim response
+// sent ahead of the final one.
+func (code StatusCode) Informational() bool {
+  return code >= 100 && code <= 199
+}
+
+// validReasonPhrase reports whether reason can follow the status code,
+// reason-phrase = *( HTAB / SP / VCHAR / obs-text ).
+func validReasonPhrase(reason string) bool {
+  for i := 0; i < len(reason); i++ {
+    c := reason[i]
+    if c != '\t' && (c < ' ' || c == 0x7f) {
+      return false
+    }
+  }
+  return true
+}
@@ -0,0 +1,122 @@
+package response
+
+import (
+  "fmt"
+  "http-from-tcp/internal/headers"
+  "slices"
+  "strings"
+)
+
+// forbiddenTrailers are the fields a recipient needs before the body, or
+// that would let the sender sneak in what it couldn't say in the header
+// section: framing, routing, request modifiers, authentication, response
+// control data and content metadata (RFC 9110 section 6.5.1).
+var forbiddenTrailers = map[string]bool{
+  "Age": true,
+  "Authorization": true,
+  "Cache-Control": true,
+  "Connection": true,
+  "Content-Encoding": true,
+  "Content-Length": true,
+  "Content-Range": true,
+  "Content-Type": true,
+  "Date": true,
+  "Expect": true,
+  "Expires": true,
+  "Host": true,
+  "If-Match": true,
+  "If-Modified-Since": true,
+  "If-None-Match": true,
+  "If-Range": true,
+  "If-Unmodified-Since": true,
+  "Keep-Alive": true,
+  "Location": true,
+  "Max-Forwards": true,
+  "Pragma": true,
+  "Proxy-Authenticate": true,
+  "Proxy-Authorization": true,
+  "Range": true,
+  "Retry-After": true,
+  "Set-Cookie": true,
+  "Te": true,
+  "Trailer": true,
+  "Transfer-Encoding": true,
+  "Upgrade": true,
+  "Vary": true,
+  "Www-Authenticate": true,
+}
+
+// DeclareTrailer announces fields that will be sent in the trailer section,
+// after a chunked body. It has to be called before the headers are written,
+// a Trailer field in the headers passed to WriteHeaders declares fields
+// too. Only declared fields can be set as trailers.
+//
+// Trailers are only sent if the client said it accepts them, see
+// AcceptsTrailers, and the body is chunked. Otherwise they are dropped,
+// along with the Trailer field.
+func (w *Writer) DeclareTrailer(names ...string) error {
+  if w.WriterState > WriterStateHeaders {
+    return fmt.Errorf("invalid state, headers already written")
+  }
+  for _, name := range names {
+    if !headers.IsToken(name) {
+      return fmt.Errorf("invalid trailer name %q", name)
+    }
+    name = headers.CanonicalName(name)
+    if forbiddenTrailers[name] {
+      return fmt.Errorf("%s can't be sent as a trailer", name)
+    }
+    if !slices.Contains(w.trailerNames, name) {
+      w.trailerNames = append(w.trailerNames, name)
+    }
+  }
+  return nil
+}
+
+// SetTrailer sets the value of the declared trailer field name. It can be
+// called at any time until the body is done, typically once it is known,
+// such as a checksum of the body.
+func (w *Writer) SetTrailer(name, value string) error {
+  if w.WriterState == WriterStateDone {
+    return fmt.Errorf("invalid state, body already done")
+  }
+  if err := w.checkTrailer(name, value); err != nil {
+    return err
+  }
+  if w.trailer == nil {
+    w.trailer = headers.NewHeaders()
+  }
+  w.trailer.Set(name, value)
+  return nil
+}
+
+// checkTrailer reports why name can't be sent as a trailer with value, if
+// it can't.
+func (w *Writer) checkTrailer(name, value string) error {
+  if !slices.Contains(w.trailerNames, headers.CanonicalName(name)) {
+    return fmt.Errorf("trailer %s was not declared", name)
+  }
+  if err := headers.ValidateField(name, value, w.ObsText); err != nil {
+    return fmt.Errorf("invalid trailer: %w", err)
+  }
+  return nil
+}
+
+// declareTrailers takes the trailers h declares and, if they are going to be
+// sent, sets its Trailer field to all of the declared ones.
+func (w *Writer) declareTrailers(h *headers.Headers) error {
+  if err := w.DeclareTrailer(h.Tokens("Trailer")...); err != nil {
+    return err
+  }
+  h.Del("Trailer")
+  if w.sendsTrailers(h) {
+    h.Set("Trailer", strings.Join(w.trailerNames, ", "))
+  }
+  return nil
+}
+
+// sendsTrailers reports whether a response with headers h gets a trailer
+// section with the declared trailers in it.
+func (w *Writer) sendsTrailers(h *headers.Headers) bool {
+  return len(w.trailerNames) > 0 && w.AcceptsTrailers && h.HasToken("Transfer-Encoding", "chunked")
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+	"io"
+	"strings"
+)
+
+var errBodyNotRequested = errors.New("request body never requested from the client")
+
+// expectation checks the Expect header of a request. It returns whether the
+// client is waiting for a 100 Continue, or the error to answer it with if
+// it expects something we don't know about.
+func expectation(r *request.Request) (bool, *HandlerError) {
+  expect := r.Headers.Get("Expect")
+  // HTTP/1.0 clients can't know what 100 Continue means
+  if expect == "" || r.RequestLine.HttpVersion == "1.0" {
+    return false, nil
+  }
+  if !strings.EqualFold(expect, "100-continue") {
+    return false, &HandlerError{
+      StatusCode: response.StatusCode417,
+      Message: "Unsupported expectation: " + expect + "\n",
+    }
+  }
+  return r.Body != request.NoBody, nil
+}
+
+// continueBody wraps the body of a request whose client waits for a
+// 100 Continue before sending it. The interim response is sent when the
+// handler first reads the body, a handler that answers without reading
+// it never asks the client for it.
+type continueBody struct {
+  body io.ReadCloser
+  w *response.Writer
+}
+
+func (b *continueBody) Read(p []byte) (int, error) {
+  if b.w.ExpectContinue && b.w.WriterState == response.WriterStateStatusLine {
+    if err := b.w.WriteContinue(); err != nil {
+      return 0, err
+    }
+  }
+  return b.body.Read(p)
+}
+
+// Close only discards the rest of the body if the client has been asked for
+// it, otherwise we'd be waiting for bytes that may never come.
+func (b *continueBody) Close() error {
+  if b.w.ExpectContinue {
+    return errBodyNotRequested
+  }
+  return b.body.Close()
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+  "fmt"
+  "http-from-tcp/internal/request"
+  "http-from-tcp/internal/response"
+  "net"
+)
+
+// connSlot is the response slot a handler writes to, with what it takes to
+// hand the connection over to the handler.
+type connSlot struct {
+  *responseSlot
+  conn net.Conn
+  reader *request.Reader
+  // exclusive is set if the connection is left alone while the handler
+  // runs, no other request is read or handled in the meantime.
+  exclusive bool
+  hijacked bool
+}
+
+// Hijack hands the connection over. Only a handler that has the connection
+// to itself can have it, otherwise the requests pipelined after its own
+// would be lost, and a request being read could be cut in half.
+func (s *connSlot) Hijack() (net.Conn, []byte, error) {
+  q := s.queue
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  if !s.exclusive {
+    return nil, nil, fmt.Errorf("%w: requests pipelined after this one are being read", response.ErrNotHijackable)
+  }
+  if len(q.slots) != 1 {
+    return nil, nil, fmt.Errorf("%w: responses to other requests are still pending", response.ErrNotHijackable)
+  }
+  if q.closed || q.err != nil {
+    return nil, nil, fmt.Errorf("%w: connection is closing", response.ErrNotHijackable)
+  }
+  s.hijacked = true
+  q.hijacked = true
+  // nothing else may be written to the connection, not even an error
+  q.closed = true
+  return s.conn, s.reader.Detach(), nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+var errResponseDiscarded = errors.New("connection is closing, response discarded")
+
+// responseQueue hands out one slot per pipelined request and makes sure the
+// slots reach the connection in the order they were handed out. The slot at
+// the head of the queue writes straight through, every other slot buffers
+// until all of the slots before it have finished.
+type responseQueue struct {
+  mu sync.Mutex
+  conn io.Writer
+  slots []*responseSlot
+  closed bool // a finished response closed the connection, or it was hijacked
+  hijacked bool // a handler took the connection over
+  err error
+}
+
+type responseSlot struct {
+  queue *responseQueue
+  buf bytes.Buffer
+  done bool
+  keepAlive bool
+}
+
+func newResponseQueue(conn io.Writer) *responseQueue {
+  return &responseQueue{conn: conn}
+}
+
+// next reserves the slot for the response to the next request read off of
+// the connection.
+func (q *responseQueue) next() *responseSlot {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  slot := &responseSlot{queue: q}
+  q.slots = append(q.slots, slot)
+  return slot
+}
+
+// Closed reports whether a response that has been written asked for the
+// connection to be closed, or writing to the connection failed.
+func (q *responseQueue) Closed() bool {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  return q.closed || q.err != nil
+}
+
+// Hijacked reports whether a handler took the connection over, the server
+// must leave it alone.
+func (q *responseQueue) Hijacked() bool {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  return q.hijacked
+}
+
+func (s *responseSlot) Write(p []byte) (int, error) {
+  q := s.queue
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  if q.err != nil {
+    return 0, q.err
+  }
+  if q.closed {
+    return 0, errResponseDiscarded
+  }
+  if q.slots[0] != s {
+    return s.buf.Write(p)
+  }
+  n, err := q.conn.Write(p)
+  if err != nil {
+    q.err = err
+  }
+  return n, err
+}
+
+// ReadFrom copies src to the connection, with the connection's own ReadFrom
+// if the slot is at the head of the queue, which for a file is left to the
+// kernel. Other slots buffer it through Write, a piece at a time, so the
+// queue isn't locked while src is being read.
+func (s *responseSlot) ReadFrom(src io.Reader) (int64, error) {
+  q := s.queue
+  q.mu.Lock()
+  if q.err != nil {
+    q.mu.Unlock()
+    return 0, q.err
+  }
+  if q.closed {
+    q.mu.Unlock()
+    return 0, errResponseDiscarded
+  }
+  head := q.slots[0] == s
+  q.mu.Unlock()
+  if !head {
+    // hides ReadFrom, io.Copy would call it again
+    return io.Copy(struct{ io.Writer }{s}, src)
+  }
+  // the head stays the head until its own response finishes, nothing else
+  // writes to the connection meanwhile
+  var n int64
+  var err error
+  if rf, ok := q.conn.(io.ReaderFrom); ok {
+    n, err = rf.ReadFrom(src)
+  } else {
+    n, err = io.Copy(q.conn, src)
+  }
+  if err != nil {
+    q.mu.Lock()
+    q.err = err
+    q.mu.Unlock()
+  }
+  return n, err
+}
+
+// finish marks the response as complete. Once every response before it has
+// finished too, the queue moves on and flushes whatever the following
+// responses buffered in the meantime.
+func (s *responseSlot) finish(keepAlive bool) {
+  q := s.queue
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  s.done = true
+  s.keepAlive = keepAlive
+  for len(q.slots) > 0 && q.slots[0].done {
+    if !q.slots[0].keepAlive {
+      q.closed = true
+    }
+    q.slots = q.slots[1:]
+    if len(q.slots) == 0 {
+      break
+    }
+    head := q.slots[0]
+    if q.err == nil && !q.closed && head.buf.Len() > 0 {
+      _, q.err = q.conn.Write(head.buf.Bytes())
+    }
+    head.buf = bytes.Buffer{}
+  }
+}
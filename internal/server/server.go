@@ -2,13 +2,18 @@ package server
 
 import (
 	// "bytes"
-	// "fmt"
+	"errors"
+	"fmt"
 	"http-from-tcp/internal/request"
 	"http-from-tcp/internal/response"
 	"io"
 	"log"
 	"net"
+	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type HandlerError struct {
@@ -16,84 +21,248 @@ type HandlerError struct {
   Message string
 }
 
+// requestError maps an error from reading a request to the response the
+// client should get, or nil if the client is gone or never sent anything
+// wrong and the connection should just be closed.
+func requestError(err error) *HandlerError {
+  var parseErr *request.ParseError
+  if !errors.As(err, &parseErr) {
+    return nil
+  }
+  return &HandlerError{
+    StatusCode: response.StatusCode(parseErr.StatusCode),
+    Message: parseErr.Error() + "\n",
+  }
+}
+
 // type Handler func(w io.Writer, req *request.Request) *HandlerError
+// Handler responds to a request. HEAD requests are handed to it as GET
+// requests with w.Head set, and the body it writes is discarded.
 type Handler func(w *response.Writer, req *request.Request)
 
+// Config controls how the server manages persistent connections.
+type Config struct {
+  // IdleTimeout is how long a connection may wait for its next request
+  // before it is closed. Zero means no timeout.
+  IdleTimeout time.Duration
+  // MaxRequestsPerConn caps the number of requests served on a single
+  // connection. Zero means no limit.
+  MaxRequestsPerConn int
+  // Request caps the size of the requests the server accepts.
+  Request request.Config
+}
+
+func DefaultConfig() Config {
+  return Config{
+    IdleTimeout: 60 * time.Second,
+    MaxRequestsPerConn: 100,
+    Request: request.DefaultConfig(),
+  }
+}
+
 type Server struct {
   listener net.Listener
-  closed bool
+  config Config
+  closed atomic.Bool
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+  return ServeWithConfig(port, handler, DefaultConfig())
+}
+
+func ServeWithConfig(port int, handler Handler, config Config) (*Server, error) {
   listener, err := net.Listen("tcp", string(":" + strconv.Itoa(port)))
-  server := &Server{listener: listener, closed: false}
   if err != nil {
     return nil, err
   }
+  server := &Server{listener: listener, config: config}
   go server.listen(handler)
   return server, nil
 }
 
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+  return s.listener.Addr()
+}
+
 func (s *Server) Close() error {
+  s.closed.Store(true)
   return s.listener.Close()
 }
 
 func (s *Server) listen(h Handler) {
   for {
     conn, err := s.listener.Accept()
-    if err != nil {
-      log.Fatal("error listening: %s", err.Error())
-    }
-    if s.closed {
+    if s.closed.Load() {
       return
     }
+    if err != nil {
+      log.Fatalf("error listening: %s", err.Error())
+    }
 
     go s.handle(conn, h)
   }
 }
 
 func (s *Server) handle(conn net.Conn, h Handler) {
-  defer conn.Close()
+  reader := request.NewReaderWithConfig(conn, s.config.Request)
+  responses := newResponseQueue(conn)
+  defer func() {
+    if !responses.Hijacked() {
+      closeConn(conn)
+    }
+  }()
+  var inFlight sync.WaitGroup
+  defer inFlight.Wait()
+  for served := 1; ; served++ {
+    // Pipelined requests that are already buffered are parsed right away so
+    // their handlers can run concurrently. Otherwise we wait for the handlers
+    // in flight first, one of them may still close the connection.
+    if reader.Buffered() == 0 {
+      inFlight.Wait()
+    }
+    if responses.Closed() {
+      return
+    }
 
-	r, err := request.RequestFromReader(conn)
-  if err != nil {
-    log.Fatal("error getting request: ", err)
+    if s.config.IdleTimeout > 0 {
+      conn.SetReadDeadline(time.Now().Add(s.config.IdleTimeout))
+    }
+    r, err := reader.ReadRequest()
+    if handlerErr := requestError(err); handlerErr != nil {
+      inFlight.Wait()
+      writeHandlerError(responses.next(), handlerErr)
+      return
+    }
+    if err != nil {
+      if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded) {
+        log.Println("error getting request: ", err)
+      }
+      return
+    }
+    conn.SetReadDeadline(time.Time{})
+
+    expectContinue, handlerErr := expectation(r)
+    if handlerErr != nil {
+      inFlight.Wait()
+      writeHandlerError(responses.next(), handlerErr)
+      return
+    }
+
+    keepAlive := r.KeepAlive()
+    if s.config.MaxRequestsPerConn > 0 && served >= s.config.MaxRequestsPerConn {
+      keepAlive = false
+    }
+    head := r.RequestLine.Method == "HEAD"
+    if head {
+      r.RequestLine.Method = "GET"
+    }
+    // a request body is read off of the connection while the handler runs,
+    // nothing else can read from it in the meantime, and a request to switch
+    // protocols is likely to end with the handler taking the connection
+    parallel := isSafeMethod(r.RequestLine.Method) && r.Body == request.NoBody && !r.Headers.Has("Upgrade")
+    // the loop only reads ahead while a handler runs if more requests are
+    // already buffered, otherwise it waits for the handler first
+    exclusive := !parallel || reader.Buffered() == 0
+    slot := &connSlot{responseSlot: responses.next(), conn: conn, reader: reader, exclusive: exclusive}
+    w := &response.Writer{
+      Writer: slot,
+      WriterState: response.WriterStateStatusLine,
+      KeepAlive: keepAlive,
+      HttpVersion: r.RequestLine.HttpVersion,
+      ExpectContinue: expectContinue,
+      // responses are held to the same policy as requests
+      ObsText: s.config.Request.ObsText,
+      Head: head,
+      AcceptsTrailers: r.Headers.HasToken("TE", "trailers"),
+    }
+    if expectContinue {
+      r.Body = &continueBody{body: r.Body, w: w}
+    }
+    serve := func() {
+      h(w, r)
+      if slot.hijacked {
+        slot.finish(false)
+        return
+      }
+      // whatever the handler left of the body has to go before the next
+      // request can be read
+      bodyErr := r.Body.Close()
+      // the handler may not have noticed, or cared, that the body went over
+      // a limit
+      if handlerErr := requestError(bodyErr); handlerErr != nil && w.WriterState == response.WriterStateStatusLine {
+        writeHandlerError(w.Writer, handlerErr)
+      } else {
+        // sends what the handler left buffered, or ends its chunked body
+        w.Finish()
+      }
+      drained := bodyErr == nil
+      // a handler that never got to its headers left the client with no way
+      // to find the end of the response
+      slot.finish(drained && w.KeepAlive && w.WriterState >= response.WriterStateBody)
+    }
+    if parallel {
+      inFlight.Add(1)
+      go func() {
+        defer inFlight.Done()
+        serve()
+      }()
+    } else {
+      // requests that may change state are not run alongside others
+      inFlight.Wait()
+      serve()
+    }
+    if !keepAlive {
+      return
+    }
   }
-  w := response.Writer{
-    Writer: conn,
-    WriterState: response.WriterStateStatusLine,
+}
+
+// closeLinger is how long closeConn keeps reading from a connection it has
+// stopped writing to.
+const closeLinger = 500 * time.Millisecond
+
+// closeConn half closes the connection and discards anything the client
+// still sends for a little while before closing it. Closing a socket with
+// unread data makes the kernel reset it, and the client could lose the last
+// response before it got to read it.
+func closeConn(conn net.Conn) {
+  if tcp, ok := conn.(*net.TCPConn); ok {
+    tcp.CloseWrite()
+    tcp.SetReadDeadline(time.Now().Add(closeLinger))
+    io.Copy(io.Discard, tcp)
   }
-  h(&w, r)
-  // err = response.WriteStatusLine(conn, response.StatusCode200)
-  // if err != nil {
-  //   log.Fatal("error writing status line")
-  // }
-  // headers := response.GetDefaultHeaders(b.Len())
-  // err = response.WriteHeaders(conn, headers)
-  // if err != nil {
-  //   log.Fatal("error writing headers", err)
-  // }
-  // // fmt.Printf("%s", b.Bytes())
-  // _, err = conn.Write(b.Bytes())
-  // if err != nil {
-  //   log.Fatal("error writing response body", err)
-  // }
+  conn.Close()
+}
+
+// isSafeMethod reports whether requests with this method may be handled in
+// parallel with the other requests pipelined on the connection (RFC 9112
+// section 9.3.2).
+func isSafeMethod(method string) bool {
+  switch method {
+  case "GET", "HEAD", "OPTIONS", "TRACE":
+    return true
+  }
+  return false
 }
 
 func writeHandlerError(w io.Writer, h *HandlerError) error {
   err := response.WriteStatusLine(w, h.StatusCode)
   if err != nil {
-    log.Fatal("error writing status line", err)
+    return fmt.Errorf("error writing status line: %w", err)
   }
   headers := response.GetDefaultHeaders(len(h.Message))
+  headers.Del("Content-Type")
+  headers.Set("Content-Type", "text/plain")
+  headers.Set("Connection", "close")
   err = response.WriteHeaders(w, headers)
   if err != nil {
-    log.Fatal("error writing headers", err)
+    return fmt.Errorf("error writing headers: %w", err)
   }
   // fmt.Printf("%s\n", h.Message)
   _, err = w.Write([]byte(h.Message))
   if err != nil {
-    log.Fatal("error writing headers", err)
+    return fmt.Errorf("error writing body: %w", err)
   }
-  return err
-}
\ No newline at end of file
+  return nil
+}
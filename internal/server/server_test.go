@@ -0,0 +1,763 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"http-from-tcp/internal/request"
+	"http-from-tcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoTargetHandler(w *response.Writer, req *request.Request) {
+	body := req.RequestLine.RequestTarget
+	w.WriteStatusLine(response.StatusCode200)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func startServer(t testing.TB, h Handler, config Config) net.Conn {
+	t.Helper()
+	s, err := ServeWithConfig(0, h, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// readHead reads a status line and header section, leaving any body unread.
+func readHead(r *bufio.Reader) (string, map[string]string, error) {
+	statusLine, err := r.ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	h := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		if line == "\r\n" {
+			return statusLine, h, nil
+		}
+		key, value, found := strings.Cut(strings.TrimSuffix(line, "\r\n"), ":")
+		if !found {
+			return "", nil, fmt.Errorf("malformed header line %q", line)
+		}
+		h[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+}
+
+// readResponse reads a single Content-Length delimited response and returns
+// its status line, lowercased headers and body.
+func readResponse(t *testing.T, r *bufio.Reader) (string, map[string]string, string) {
+	t.Helper()
+	statusLine, h, err := readHead(r)
+	require.NoError(t, err)
+	body := []byte{}
+	if cl, ok := h["content-length"]; ok {
+		n, err := strconv.Atoi(cl)
+		require.NoError(t, err)
+		body = make([]byte, n)
+		_, err = io.ReadFull(r, body)
+		require.NoError(t, err)
+	}
+	return statusLine, h, string(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Several requests served on one connection
+	conn := startServer(t, echoTargetHandler, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	for _, target := range []string{"/one", "/two", "/three"} {
+		_, err := conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		statusLine, h, body := readResponse(t, reader)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+		assert.Equal(t, target, body)
+		assert.NotEqual(t, "close", h["connection"])
+	}
+
+	// Test: Client asks to close the connection
+	_, err := conn.Write([]byte("GET /last HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, body := readResponse(t, reader)
+	assert.Equal(t, "/last", body)
+	assert.Equal(t, "close", h["connection"])
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	conn := startServer(t, echoTargetHandler, Config{MaxRequestsPerConn: 2})
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /one HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, _ := readResponse(t, reader)
+	assert.NotEqual(t, "close", h["connection"])
+	_, err = conn.Write([]byte("GET /two HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, _ = readResponse(t, reader)
+	assert.Equal(t, "close", h["connection"])
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	conn := startServer(t, echoTargetHandler, Config{IdleTimeout: 50 * time.Millisecond})
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /one HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	readResponse(t, reader)
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPipelining(t *testing.T) {
+	// Test: Responses come back in request order even when the handlers
+	// finish out of order
+	h := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		echoTargetHandler(w, req)
+	}
+	conn := startServer(t, h, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte(
+		"GET /slow HTTP/1.1\r\n\r\n" +
+			"GET /fast HTTP/1.1\r\n\r\n" +
+			"POST /post HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi" +
+			"GET /last HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	for _, target := range []string{"/slow", "/fast", "/post", "/last"} {
+		_, _, body := readResponse(t, reader)
+		assert.Equal(t, target, body)
+	}
+
+	// Test: Nothing is written after a response that closes the connection
+	conn = startServer(t, h, DefaultConfig())
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte(
+		"GET /slow HTTP/1.1\r\nConnection: close\r\n\r\n" +
+			"GET /fast HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, headers, body := readResponse(t, reader)
+	assert.Equal(t, "/slow", body)
+	assert.Equal(t, "close", headers["connection"])
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: A response copying its body while pipelined doesn't hold up the
+	// one ahead of it, the body only arrives once that one has been read
+	copying := make(chan struct{})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	conn = startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/copy" {
+			w.WriteStatusLine(response.StatusCode200)
+			w.WriteHeaders(response.GetDefaultHeaders(5))
+			close(copying)
+			io.Copy(w, pr)
+			return
+		}
+		<-copying
+		time.Sleep(50 * time.Millisecond)
+		echoTargetHandler(w, req)
+	}, DefaultConfig())
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET /first HTTP/1.1\r\n\r\nGET /copy HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, body = readResponse(t, reader)
+	assert.Equal(t, "/first", body)
+	_, err = pw.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, _, body = readResponse(t, reader)
+	assert.Equal(t, "hello", body)
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Handlers read the body themselves, whatever they leave unread is
+	// discarded before the next request
+	h := func(w *response.Writer, req *request.Request) {
+		body := ""
+		if req.RequestLine.RequestTarget == "/echo" {
+			b, err := io.ReadAll(req.Body)
+			assert.NoError(t, err)
+			body = string(b)
+		}
+		w.WriteStatusLine(response.StatusCode200)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+	conn := startServer(t, h, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte(
+		"POST /ignore HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+			"POST /echo HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nworld\r\n0\r\n\r\n" +
+			"GET /ignore HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	for _, expected := range []string{"", "world", ""} {
+		statusLine, _, body := readResponse(t, reader)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+		assert.Equal(t, expected, body)
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 connections close by default
+	conn := startServer(t, echoTargetHandler, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /old HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	statusLine, h, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n", statusLine)
+	assert.Equal(t, "close", h["connection"])
+	assert.Equal(t, "/old", body)
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: HTTP/1.0 keep-alive
+	conn = startServer(t, echoTargetHandler, DefaultConfig())
+	reader = bufio.NewReader(conn)
+	for _, target := range []string{"/one", "/two"} {
+		_, err = conn.Write([]byte("GET " + target + " HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		statusLine, h, body = readResponse(t, reader)
+		assert.Equal(t, "HTTP/1.0 200 OK\r\n", statusLine)
+		assert.Equal(t, "keep-alive", h["connection"])
+		assert.Equal(t, target, body)
+	}
+
+	// Test: Chunked responses fall back to closing the connection
+	chunked := func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Del("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusCode200)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello"))
+		w.WriteChunkedBodyDone(nil)
+	}
+	conn = startServer(t, chunked, DefaultConfig())
+	_, err = conn.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.0 200 OK\r\n"))
+	assert.NotContains(t, strings.ToLower(string(raw)), "transfer-encoding")
+	assert.Contains(t, string(raw), "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\nhello"))
+
+	// Test: Unsupported major version
+	conn = startServer(t, echoTargetHandler, DefaultConfig())
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET / HTTP/2.0\r\n\r\n"))
+	require.NoError(t, err)
+	statusLine, _, _ = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 505 HTTP Version Not Supported\r\n", statusLine)
+}
+
+func TestRequestLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.Request = request.Config{MaxRequestLineBytes: 20, MaxHeaderBytes: 40, MaxHeaderCount: 2, MaxBodyBytes: 5}
+	// a handler that gives up on requests whose body it can't read
+	h := func(w *response.Writer, req *request.Request) {
+		if _, err := io.ReadAll(req.Body); err != nil {
+			return
+		}
+		echoTargetHandler(w, req)
+	}
+	for _, tc := range []struct {
+		request    string
+		statusLine string
+	}{
+		{"GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n", "HTTP/1.1 414 URI Too Long\r\n"},
+		{"GET / HTTP/1.1\r\nA: " + strings.Repeat("a", 100) + "\r\n\r\n", "HTTP/1.1 431 Request Header Fields Too Large\r\n"},
+		{"POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\n", "HTTP/1.1 413 Content Too Large\r\n"},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello!\r\n0\r\n\r\n", "HTTP/1.1 413 Content Too Large\r\n"},
+	} {
+		conn := startServer(t, h, config)
+		reader := bufio.NewReader(conn)
+		_, err := conn.Write([]byte(tc.request))
+		require.NoError(t, err)
+		statusLine, h, _ := readResponse(t, reader)
+		assert.Equal(t, tc.statusLine, statusLine)
+		assert.Equal(t, "close", h["connection"])
+	}
+}
+
+func TestMalformedRequests(t *testing.T) {
+	for _, tc := range []struct {
+		request    string
+		statusLine string
+	}{
+		{"GET /\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n"},
+		{"GET / HTTP/1.1\r\nHost localhost\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n"},
+		{"BREW /pot HTTP/1.1\r\n\r\n", "HTTP/1.1 501 Not Implemented\r\n"},
+		{"GET / HTTP/2.0\r\n\r\n", "HTTP/1.1 505 HTTP Version Not Supported\r\n"},
+	} {
+		conn := startServer(t, echoTargetHandler, DefaultConfig())
+		reader := bufio.NewReader(conn)
+		_, err := conn.Write([]byte(tc.request))
+		require.NoError(t, err)
+		statusLine, h, body := readResponse(t, reader)
+		assert.Equal(t, tc.statusLine, statusLine)
+		assert.Equal(t, "close", h["connection"])
+		assert.Equal(t, "text/plain", h["content-type"])
+		assert.NotEmpty(t, body)
+		_, err = reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+
+	// Test: A bad request only closes its own connection
+	conn := startServer(t, echoTargetHandler, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /ok HTTP/1.1\r\n\r\nGET /\r\n\r\n"))
+	require.NoError(t, err)
+	statusLine, _, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "/ok", body)
+	statusLine, _, _ = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", statusLine)
+
+	// Test: A client closing its side in the middle of the headers gets its
+	// connection closed, the partial request never reaches the handler
+	for _, config := range []Config{DefaultConfig(), {}} {
+		calls := make(chan string, 100)
+		conn = startServer(t, func(w *response.Writer, req *request.Request) {
+			calls <- req.RequestLine.RequestTarget
+			echoTargetHandler(w, req)
+		}, config)
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nX-Partial: a"))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+		_, err = io.ReadAll(conn)
+		assert.NoError(t, err)
+		assert.Empty(t, calls)
+	}
+}
+
+func TestAutomaticFraming(t *testing.T) {
+	h := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch req.RequestLine.RequestTarget {
+		case "/missing":
+			w.WriteHeader(response.StatusCode404)
+			io.WriteString(w, "not found")
+		case "/large":
+			for i := 0; i < 100; i++ {
+				io.WriteString(w, strings.Repeat("x", 99)+"\n")
+			}
+		case "/empty":
+		}
+	}
+	conn := startServer(t, h, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /missing HTTP/1.1\r\n\r\nGET /empty HTTP/1.1\r\n\r\nGET /large HTTP/1.1\r\n\r\nGET /missing HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	statusLine, headers, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", statusLine)
+	assert.Equal(t, "9", headers["content-length"])
+	assert.Equal(t, "not found", body)
+
+	statusLine, headers, body = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "0", headers["content-length"])
+	assert.Equal(t, "", body)
+
+	statusLine, headers, _ = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "chunked", headers["transfer-encoding"])
+	large, err := io.ReadAll(httputil.NewChunkedReader(reader))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat(strings.Repeat("x", 99)+"\n", 100), string(large))
+	trailerEnd, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", trailerEnd)
+
+	// the connection survives all of them
+	statusLine, _, body = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", statusLine)
+	assert.Equal(t, "not found", body)
+}
+
+func TestHead(t *testing.T) {
+	h := func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/large":
+			io.WriteString(w, strings.Repeat("x", 10000))
+		case "/created":
+			w.WriteHeader(response.StatusCode204)
+		default:
+			echoTargetHandler(w, req)
+		}
+	}
+	conn := startServer(t, h, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("HEAD /large HTTP/1.1\r\n\r\nHEAD /echo HTTP/1.1\r\n\r\nGET /created HTTP/1.1\r\n\r\nGET /echo HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: HEAD gets the headers a GET would, without the body
+	statusLine, headers, err := readHead(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "10000", headers["content-length"])
+	statusLine, headers, err = readHead(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "5", headers["content-length"])
+
+	// Test: 204 is sent without framing
+	statusLine, headers, err = readHead(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n", statusLine)
+	assert.NotContains(t, headers, "content-length")
+
+	// the connection survives all of them
+	statusLine, _, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "/echo", body)
+}
+
+func TestEarlyHints(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(response.StatusCode103)
+		io.WriteString(w, "page")
+	}, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\nGET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+
+	statusLine, headers, err := readHead(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\n", statusLine)
+	assert.Equal(t, "</style.css>; rel=preload; as=style", headers["link"])
+	statusLine, headers, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "</style.css>; rel=preload; as=style", headers["link"])
+	assert.Equal(t, "page", body)
+
+	// Test: HTTP/1.0 clients only get the final response
+	statusLine, _, body = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n", statusLine)
+	assert.Equal(t, "page", body)
+}
+
+func TestTrailers(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.DeclareTrailer("X-Checksum")
+		io.WriteString(w, "hello")
+		w.SetTrailer("X-Checksum", "abc")
+	}, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nTE: trailers\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Sent to a client that accepts them
+	statusLine, headers, err := readHead(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "X-Checksum", headers["trailer"])
+	body, err := io.ReadAll(httputil.NewChunkedReader(reader))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	trailer, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "X-Checksum: abc\r\n", trailer)
+	end, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", end)
+
+	// Test: Dropped for one that doesn't
+	statusLine, headers, body2 := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.NotContains(t, headers, "trailer")
+	assert.Equal(t, "hello", body2)
+}
+
+func TestDigestMismatch(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		w.Write(body)
+	}, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 5\r\n" +
+		"Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:\r\n\r\nhello"))
+	require.NoError(t, err)
+	statusLine, _, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", statusLine)
+	assert.Contains(t, body, "digest")
+}
+
+func TestHijack(t *testing.T) {
+	hijackErr := make(chan error, 1)
+	h := func(w *response.Writer, req *request.Request) {
+		if !strings.HasPrefix(req.RequestLine.RequestTarget, "/echo") {
+			echoTargetHandler(w, req)
+			return
+		}
+		c, buffered, err := w.Hijack()
+		hijackErr <- err
+		if err != nil {
+			echoTargetHandler(w, req)
+			return
+		}
+		defer c.Close()
+		io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		// echoes lines until told to stop, starting with those the server
+		// had already read
+		lines := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), c))
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil || line == "bye\n" {
+				return
+			}
+			io.WriteString(c, strings.ToUpper(line))
+		}
+	}
+	// speaks the echo protocol over conn after the 101 response, expecting
+	// the lines in echoed first
+	echo := func(conn net.Conn, reader *bufio.Reader, echoed ...string) {
+		statusLine, headers, err := readHead(reader)
+		require.NoError(t, err)
+		require.NoError(t, <-hijackErr)
+		assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", statusLine)
+		assert.Equal(t, "echo", headers["upgrade"])
+		for _, expected := range echoed {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, expected, line)
+		}
+		_, err = conn.Write([]byte("GET / HTTP/1.1\n"))
+		require.NoError(t, err)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "GET / HTTP/1.1\n", line, "the server no longer parses requests")
+		_, err = conn.Write([]byte("bye\n"))
+		require.NoError(t, err)
+		rest, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Empty(t, rest)
+	}
+
+	// Test: A request with others pipelined behind it can't take the
+	// connection
+	conn := startServer(t, h, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /echo HTTP/1.1\r\n\r\nGET /after HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	for _, target := range []string{"/echo", "/after"} {
+		statusLine, _, body := readResponse(t, reader)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+		assert.Equal(t, target, body)
+	}
+	err = <-hijackErr
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+	assert.Contains(t, err.Error(), "pipelined")
+
+	// Test: A plain GET on its own can
+	_, err = conn.Write([]byte("GET /echo HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	echo(conn, reader)
+
+	// Test: As can a request to switch protocols, along with what followed it
+	conn = startServer(t, h, DefaultConfig())
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET /echo HTTP/1.1\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nhello\n"))
+	require.NoError(t, err)
+	echo(conn, reader, "HELLO\n")
+}
+
+func TestServeFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		f, err := os.Open(path)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer f.Close()
+		if req.RequestLine.RequestTarget == "/chunked" {
+			w.Header().Set("Transfer-Encoding", "chunked")
+		}
+		io.Copy(w, f)
+	}, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\nGET /chunked HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Sent with a Content-Length
+	statusLine, headers, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, strconv.Itoa(len(content)), headers["content-length"])
+	assert.True(t, string(content) == body)
+
+	// Test: Or chunked, while pipelined behind another response
+	_, headers, err = readHead(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "chunked", headers["transfer-encoding"])
+	chunked, err := io.ReadAll(httputil.NewChunkedReader(reader))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, chunked))
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+
+	_, _, body = readResponse(t, reader)
+	assert.True(t, string(content) == body)
+}
+
+// BenchmarkServeFile compares sending a file with io.Copy, which leaves it to
+// sendfile, with reading it into memory and writing it with WriteBody, and
+// with sending it chunked, which copies it through a buffer.
+func BenchmarkServeFile(b *testing.B) {
+	const size = 16 << 20
+	path := filepath.Join(b.TempDir(), "file")
+	require.NoError(b, os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0o644))
+	h := func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/inmemory":
+			data, err := os.ReadFile(path)
+			if !assert.NoError(b, err) {
+				return
+			}
+			w.WriteStatusLine(response.StatusCode200)
+			w.WriteHeaders(response.GetDefaultHeaders(len(data)))
+			w.WriteBody(data)
+			return
+		case "/chunked":
+			w.Header().Set("Transfer-Encoding", "chunked")
+		}
+		f, err := os.Open(path)
+		if !assert.NoError(b, err) {
+			return
+		}
+		defer f.Close()
+		io.Copy(w, f)
+	}
+	for _, target := range []string{"/sendfile", "/inmemory", "/chunked"} {
+		b.Run(strings.TrimPrefix(target, "/"), func(b *testing.B) {
+			conn := startServer(b, h, Config{})
+			conn.SetDeadline(time.Time{})
+			reader := bufio.NewReaderSize(conn, 64*1024)
+			request := []byte("GET " + target + " HTTP/1.1\r\n\r\n")
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := conn.Write(request)
+				require.NoError(b, err)
+				_, headers, err := readHead(reader)
+				require.NoError(b, err)
+				var body io.Reader = io.LimitReader(reader, size)
+				if headers["transfer-encoding"] == "chunked" {
+					body = httputil.NewChunkedReader(reader)
+				}
+				n, err := io.Copy(io.Discard, body)
+				require.NoError(b, err)
+				require.Equal(b, int64(size), n)
+				if headers["transfer-encoding"] == "chunked" {
+					_, err = reader.ReadString('\n')
+					require.NoError(b, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	handlerErr := make(chan error, 1)
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		// echoes the decoded query into a header, as a careless handler would
+		h := response.GetDefaultHeaders(0)
+		h.Set("X-Echo", req.RequestLine.URL.Query().Get("v"))
+		w.WriteStatusLine(response.StatusCode200)
+		handlerErr <- w.WriteHeaders(h)
+	}, DefaultConfig())
+	_, err := conn.Write([]byte("GET /?v=a%0d%0aSet-Cookie:%20evil=1 HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Error(t, <-handlerErr)
+
+	// the response is cut short rather than carrying the injected field
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Set-Cookie")
+	assert.NotContains(t, string(data), "X-Echo")
+}
+
+func TestExpectContinue(t *testing.T) {
+	h := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/reject" {
+			w.WriteStatusLine(response.StatusCode413)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		w.WriteStatusLine(response.StatusCode200)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+
+	// Test: 100 Continue is sent once the handler reads the body
+	conn := startServer(t, h, DefaultConfig())
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	interim, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", interim)
+	blank, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	statusLine, _, body := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	assert.Equal(t, "hello", body)
+
+	// Test: The connection is reused afterwards
+	_, err = conn.Write([]byte("POST /again HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	_, _, body = readResponse(t, reader)
+	assert.Equal(t, "hi", body)
+
+	// Test: Handler rejects the upload without reading it
+	conn = startServer(t, h, DefaultConfig())
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST /reject HTTP/1.1\r\nContent-Length: 1000000\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	statusLine, headers, _ := readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large\r\n", statusLine)
+	assert.Equal(t, "close", headers["connection"])
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unknown expectation
+	conn = startServer(t, h, DefaultConfig())
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST /upload HTTP/1.1\r\nContent-Length: 5\r\nExpect: 200-ok\r\n\r\n"))
+	require.NoError(t, err)
+	statusLine, _, _ = readResponse(t, reader)
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed\r\n", statusLine)
+}